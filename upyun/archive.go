@@ -0,0 +1,266 @@
+package upyun
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// ArchiveFormat is the format of the archive written by ArchivePrefix.
+type ArchiveFormat string
+
+const (
+	ArchiveTar   ArchiveFormat = "tar"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+	ArchiveZip   ArchiveFormat = "zip"
+)
+
+const (
+	// DefaultArchiveConcurrency is the number of objects ArchivePrefix
+	// fetches ahead of the one being written.
+	DefaultArchiveConcurrency = 4
+	// DefaultArchivePrefetchSize is the largest object fetched ahead into
+	// memory, larger ones are streamed into the archive when their turn
+	// comes.
+	DefaultArchivePrefetchSize = 4 * 1024 * 1024
+)
+
+// archiveEntry is an object to be written, data is valid once done is
+// closed, unless it is streamed.
+type archiveEntry struct {
+	info     *FileInfo
+	prefetch bool
+	done     chan struct{}
+	data     []byte
+	err      error
+}
+
+// ArchivePrefix walks the directory tree under prefix and writes every
+// object into w as an archive of format. Entries are named after the
+// relative path of the objects, empty directories are kept.
+func (up *UpYun) ArchivePrefix(prefix string, format ArchiveFormat, w io.Writer) error {
+	return up.ArchivePrefixWithContext(context.Background(), prefix, format, w)
+}
+
+func (up *UpYun) ArchivePrefixWithContext(ctx context.Context, prefix string, format ArchiveFormat, w io.Writer) (err error) {
+	ctx, span := up.startSpan(ctx, "archive", "upyun.path", prefix, "upyun.format", string(format))
+	defer func() { span.End(err) }()
+
+	aw, err := newArchiveWriter(format, w)
+	if err != nil {
+		return err
+	}
+	// listing a missing folder may well succeed with nothing
+	if p := strings.Trim(prefix, "/"); p != "" {
+		fInfo, err := up.GetInfoWithContext(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		if !fInfo.IsDir {
+			return fmt.Errorf("archive %s: not a directory", prefix)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := make(chan *FileInfo, 50)
+	listErr := make(chan error, 1)
+	go func() {
+		listErr <- up.ListWithContext(ctx, &GetObjectsConfig{
+			Path:         prefix,
+			ObjectsChan:  objects,
+			MaxListLevel: -1,
+		})
+	}()
+
+	// the small objects are fetched in the background in the order they
+	// are listed, sem bounds the number of fetched but unwritten ones.
+	sem := make(chan struct{}, DefaultArchiveConcurrency)
+	entries := make(chan *archiveEntry, DefaultArchiveConcurrency)
+	go func() {
+		defer close(entries)
+		for fInfo := range objects {
+			if fInfo.IsDir && !fInfo.IsEmptyDir {
+				continue
+			}
+			e := &archiveEntry{info: fInfo, done: make(chan struct{})}
+			if !fInfo.IsDir && fInfo.Size <= DefaultArchivePrefetchSize {
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				e.prefetch = true
+				// the fetch may still be running when ArchivePrefix fails
+				go up.fetchArchiveEntry(withoutResponseMeta(ctx), path.Join(prefix, fInfo.Name), e)
+			} else {
+				close(e.done)
+			}
+			select {
+			case entries <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for e := range entries {
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		err = up.writeArchiveEntry(ctx, aw, prefix, e)
+		if e.prefetch {
+			e.data = nil
+			<-sem
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = <-listErr; err != nil {
+		return err
+	}
+	if err = aw.Close(); err != nil {
+		return errorOperation("archive", err)
+	}
+	return nil
+}
+
+func (up *UpYun) fetchArchiveEntry(ctx context.Context, name string, e *archiveEntry) {
+	defer close(e.done)
+	var buf bytes.Buffer
+	buf.Grow(int(e.info.Size))
+	_, e.err = up.GetWithContext(ctx, &GetObjectConfig{Path: name, Writer: &buf})
+	e.data = buf.Bytes()
+}
+
+func (up *UpYun) writeArchiveEntry(ctx context.Context, aw archiveWriter, prefix string, e *archiveEntry) error {
+	name := strings.TrimPrefix(e.info.Name, "/")
+	if e.info.IsDir {
+		if err := aw.mkdir(name, e.info); err != nil {
+			return errorOperation("archive", err)
+		}
+		return nil
+	}
+	if e.err != nil {
+		return e.err
+	}
+
+	if e.prefetch {
+		// the object may have changed since it was listed
+		ew, err := aw.create(name, e.info, int64(len(e.data)))
+		if err == nil {
+			_, err = ew.Write(e.data)
+		}
+		if err != nil {
+			return errorOperation("archive", err)
+		}
+		return nil
+	}
+
+	ew, err := aw.create(name, e.info, e.info.Size)
+	if err != nil {
+		return errorOperation("archive", err)
+	}
+	fInfo, err := up.GetWithContext(ctx, &GetObjectConfig{Path: path.Join(prefix, e.info.Name), Writer: ew})
+	if err != nil {
+		return err
+	}
+	if fInfo.Size != e.info.Size {
+		return fmt.Errorf("archive %s: object size changed from %d to %d", e.info.Name, e.info.Size, fInfo.Size)
+	}
+	return nil
+}
+
+type archiveWriter interface {
+	// create starts a file entry of size bytes
+	create(name string, fInfo *FileInfo, size int64) (io.Writer, error)
+	mkdir(name string, fInfo *FileInfo) error
+	Close() error
+}
+
+func newArchiveWriter(format ArchiveFormat, w io.Writer) (archiveWriter, error) {
+	switch format {
+	case ArchiveTar:
+		return &tarArchive{tw: tar.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarArchive{tw: tar.NewWriter(gz), gz: gz}, nil
+	case ArchiveZip:
+		return &zipArchive{zw: zip.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown archive format %q", format)
+}
+
+type tarArchive struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (a *tarArchive) create(name string, fInfo *FileInfo, size int64) (io.Writer, error) {
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  fInfo.Time,
+	})
+	return a.tw, err
+}
+
+func (a *tarArchive) mkdir(name string, fInfo *FileInfo) error {
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  fInfo.Time,
+	})
+}
+
+func (a *tarArchive) Close() error {
+	err := a.tw.Close()
+	if a.gz != nil {
+		if gerr := a.gz.Close(); err == nil {
+			err = gerr
+		}
+	}
+	return err
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) create(name string, fInfo *FileInfo, size int64) (io.Writer, error) {
+	h := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: fInfo.Time,
+	}
+	h.SetMode(0644)
+	return a.zw.CreateHeader(h)
+}
+
+func (a *zipArchive) mkdir(name string, fInfo *FileInfo) error {
+	h := &zip.FileHeader{
+		Name:     name + "/",
+		Modified: fInfo.Time,
+	}
+	h.SetMode(os.ModeDir | 0755)
+	_, err := a.zw.CreateHeader(h)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
@@ -0,0 +1,99 @@
+package upyun
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func newArchiveBucket(t *testing.T) (*UpYun, map[string][]byte) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	files := map[string][]byte{
+		"a.txt":     []byte("aaa"),
+		"sub/b.txt": []byte("bbb"),
+		"sub/big":   bytes.Repeat([]byte("B"), DefaultArchivePrefetchSize+1),
+	}
+	Nil(t, fake.Mkdir("/prefix"))
+	Nil(t, fake.Mkdir("/prefix/sub"))
+	Nil(t, fake.Mkdir("/prefix/empty"))
+	for name, data := range files {
+		bucket.put("/prefix/"+name, data)
+	}
+	bucket.put("/outside", []byte("outside"))
+	return fake, files
+}
+
+func TestArchivePrefixTar(t *testing.T) {
+	fake, files := newArchiveBucket(t)
+
+	for _, format := range []ArchiveFormat{ArchiveTar, ArchiveTarGz} {
+		var buf bytes.Buffer
+		Nil(t, fake.ArchivePrefix("/prefix", format, &buf))
+
+		var r io.Reader = &buf
+		if format == ArchiveTarGz {
+			gz, err := gzip.NewReader(&buf)
+			Nil(t, err)
+			r = gz
+		}
+		tr := tar.NewReader(r)
+		got := map[string][]byte{}
+		dirs := []string{}
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Nil(t, err)
+			Equal(t, h.ModTime.IsZero(), false)
+			if h.Typeflag == tar.TypeDir {
+				dirs = append(dirs, h.Name)
+				continue
+			}
+			b, err := ioutil.ReadAll(tr)
+			Nil(t, err)
+			Equal(t, int64(len(b)), h.Size)
+			got[h.Name] = b
+		}
+		Equal(t, len(got), len(files))
+		for name, data := range files {
+			Equal(t, bytes.Equal(got[name], data), true)
+		}
+		Equal(t, dirs, []string{"empty/"})
+	}
+}
+
+func TestArchivePrefixZip(t *testing.T) {
+	fake, files := newArchiveBucket(t)
+
+	var buf bytes.Buffer
+	Nil(t, fake.ArchivePrefix("/prefix", ArchiveZip, &buf))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	Nil(t, err)
+	got := 0
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			Equal(t, f.Name, "empty/")
+			continue
+		}
+		rc, err := f.Open()
+		Nil(t, err)
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		Nil(t, err)
+		Equal(t, bytes.Equal(b, files[f.Name]), true)
+		got++
+	}
+	Equal(t, got, len(files))
+}
+
+func TestArchivePrefixErrors(t *testing.T) {
+	fake, _ := newArchiveBucket(t)
+	NotNil(t, fake.ArchivePrefix("/prefix", ArchiveFormat("rar"), ioutil.Discard))
+	Equal(t, IsNotExist(fake.ArchivePrefix("/missing", ArchiveTar, ioutil.Discard)), true)
+	NotNil(t, fake.ArchivePrefix("/outside", ArchiveTar, ioutil.Discard))
+}
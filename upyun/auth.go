@@ -27,24 +27,27 @@ type UnifiedAuthConfig struct {
 }
 
 func (u *UpYun) MakeRESTAuth(config *RESTAuthConfig) string {
+	// a failure of the provider is reported when the request is sent
+	cred, _ := u.credentials()
 	sign := []string{
 		config.Method,
 		config.Uri,
 		config.DateStr,
 		config.LengthStr,
-		u.Password,
+		cred.PasswordMD5,
 	}
-	return "UpYun " + u.Operator + ":" + md5Str(strings.Join(sign, "&"))
+	return "UpYun " + cred.Operator + ":" + md5Str(strings.Join(sign, "&"))
 }
 
 func (u *UpYun) MakePurgeAuth(config *PurgeAuthConfig) string {
+	cred, _ := u.credentials()
 	sign := []string{
 		config.PurgeList,
 		u.Bucket,
 		config.DateStr,
-		u.Password,
+		cred.PasswordMD5,
 	}
-	return "UpYun " + u.Bucket + ":" + u.Operator + ":" + md5Str(strings.Join(sign, "&"))
+	return "UpYun " + u.Bucket + ":" + cred.Operator + ":" + md5Str(strings.Join(sign, "&"))
 }
 
 func (u *UpYun) MakeFormAuth(policy string) string {
@@ -62,7 +65,8 @@ func (u *UpYun) MakeProcessAuth(kwargs map[string]string) string {
 	for _, k := range keys {
 		auth += k + kwargs[k]
 	}
-	return fmt.Sprintf("UpYun %s:%s", u.Operator, md5Str(u.Operator+auth+u.Password))
+	cred, _ := u.credentials()
+	return fmt.Sprintf("UpYun %s:%s", cred.Operator, md5Str(cred.Operator+auth+cred.PasswordMD5))
 }
 
 func (u *UpYun) MakeUnifiedAuth(config *UnifiedAuthConfig) string {
@@ -79,6 +83,7 @@ func (u *UpYun) MakeUnifiedAuth(config *UnifiedAuthConfig) string {
 			signNoEmpty = append(signNoEmpty, v)
 		}
 	}
-	signStr := base64ToStr(hmacSha1(u.Password, []byte(strings.Join(signNoEmpty, "&"))))
-	return "UpYun " + u.Operator + ":" + signStr
+	cred, _ := u.credentials()
+	signStr := base64ToStr(hmacSha1(cred.PasswordMD5, []byte(strings.Join(signNoEmpty, "&"))))
+	return "UpYun " + cred.Operator + ":" + signStr
 }
@@ -0,0 +1,48 @@
+package upyun
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the Date header has a resolution of one second, smaller offsets
+	// are treated as no skew at all.
+	minClockSkew = 2 * time.Second
+)
+
+// ClockSkew returns the offset of the UpYun server clock to the local
+// clock, learnt from the Date header of the latest response. It is added
+// to the local time when dating and signing requests.
+func (up *UpYun) ClockSkew() time.Duration {
+	return time.Duration(atomic.LoadInt64(&up.clockSkew))
+}
+
+func (up *UpYun) now() time.Time {
+	return time.Now().Add(up.ClockSkew())
+}
+
+func (up *UpYun) learnClockSkew(header http.Header) {
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return
+	}
+	skew := time.Until(date)
+	if skew > -minClockSkew && skew < minClockSkew {
+		skew = 0
+	}
+	atomic.StoreInt64(&up.clockSkew, int64(skew))
+}
+
+// isClockSkewError reports whether the request is rejected because of its
+// Date header is too far from the server time.
+func isClockSkewError(err error) bool {
+	var ae *Error
+	if !errors.As(err, &ae) || ae.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	return ae.Code == ErrCodeDateOffset || strings.Contains(strings.ToLower(ae.Message), "date offset")
+}
@@ -0,0 +1,36 @@
+package upyun
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClockSkewResign(t *testing.T) {
+	var tries int32
+	offset := time.Hour
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tries, 1)
+		serverNow := time.Now().Add(offset)
+		w.Header().Set("Date", makeRFC1123Date(serverNow))
+
+		date, err := http.ParseTime(r.Header.Get("Date"))
+		Nil(t, err)
+		if d := serverNow.Sub(date); d > 10*time.Minute || d < -10*time.Minute {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":40100002,"msg":"date offset error"}`))
+		}
+	})
+
+	err := fake.Mkdir("/skew")
+	Nil(t, err)
+	Equal(t, atomic.LoadInt32(&tries), int32(2))
+	skew := fake.ClockSkew()
+	Equal(t, skew > offset-minClockSkew && skew < offset+minClockSkew, true)
+
+	// the following requests are dated correctly at the first attempt
+	err = fake.Mkdir("/skew")
+	Nil(t, err)
+	Equal(t, atomic.LoadInt32(&tries), int32(3))
+}
@@ -0,0 +1,543 @@
+package upyun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBucketEnv     = "UPYUN_BUCKET"
+	DefaultConfigFileEnv = "UPYUN_CONFIG_FILE"
+	DefaultProfileEnv    = "UPYUN_PROFILE"
+	DefaultProfile       = "default"
+)
+
+// ConfigError reports an invalid field of UpYunConfig, Source tells where
+// the value comes from, e.g. "env UPYUN_TIMEOUT" or "upyun.yaml [default]".
+type ConfigError struct {
+	Source string
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("upyun config: invalid %s: %s", e.Field, e.Reason)
+	}
+	return fmt.Sprintf("upyun config: invalid %s in %s: %s", e.Field, e.Source, e.Reason)
+}
+
+var md5HexRegexp = regexp.MustCompile("^[0-9a-fA-F]{32}$")
+
+// Validate checks that the required fields are set and the others are sane,
+// the returned error is a *ConfigError.
+func (c *UpYunConfig) Validate() error {
+	invalid := func(field, reason string) error {
+		return &ConfigError{Field: field, Reason: reason}
+	}
+
+	if c.Bucket == "" {
+		return invalid("bucket", "must be set")
+	}
+	if c.CredentialsProvider == nil {
+		if c.Operator == "" {
+			return invalid("operator", "must be set")
+		}
+		if c.Password == "" && c.PasswordMD5 == "" {
+			return invalid("password", "must be set")
+		}
+	} else if _, err := c.CredentialsProvider.Credentials(); err != nil {
+		if cerr, ok := err.(*ConfigError); ok {
+			return cerr
+		}
+		return invalid("credentials", err.Error())
+	}
+	if c.PasswordMD5 != "" && !md5HexRegexp.MatchString(c.PasswordMD5) {
+		return invalid("password_md5", "must be 32 hex digits")
+	}
+	switch strings.ToLower(c.Scheme) {
+	case "", "http", "https":
+	default:
+		return invalid("scheme", fmt.Sprintf("%q is neither http nor https", c.Scheme))
+	}
+	if c.Timeout < 0 {
+		return invalid("timeout", "must not be negative")
+	}
+	if c.ConnectTimeout < 0 {
+		return invalid("connect_timeout", "must not be negative")
+	}
+	if p := c.RetryPolicy; p != nil {
+		if p.MaxAttempts < 0 {
+			return invalid("retry.max_attempts", "must not be negative")
+		}
+		if p.MinBackoff < 0 {
+			return invalid("retry.min_backoff", "must not be negative")
+		}
+		if p.MaxBackoff < 0 || (p.MaxBackoff > 0 && p.MaxBackoff < p.MinBackoff) {
+			return invalid("retry.max_backoff", "must not be less than min_backoff")
+		}
+		if p.Jitter < 0 || p.Jitter > 1 {
+			return invalid("retry.jitter", "must be between 0 and 1")
+		}
+	}
+	for host, addrs := range c.Endpoints {
+		if len(addrs) == 0 {
+			return invalid("endpoints."+host, "must not be empty")
+		}
+		for _, addr := range addrs {
+			if addr == "" || strings.Contains(addr, "/") {
+				return invalid("endpoints."+host, fmt.Sprintf("%q is not a host[:port]", addr))
+			}
+		}
+	}
+	return nil
+}
+
+// LoadConfig loads the named profile of a profile file, then overrides it
+// with environment variables and validates the result.
+//
+// An empty file defaults to $UPYUN_CONFIG_FILE, no file is read if both are
+// empty. An empty profile defaults to $UPYUN_PROFILE or DefaultProfile.
+//
+// The file is either JSON or a YAML-like indented format, whose top level
+// keys are the profile names. Comments take lines of their own, a value
+// containing '#' must be quoted:
+//
+//	default:
+//	  bucket: my-bucket
+//	  operator: my-operator
+//	  # or password_md5
+//	  password: "my-password#1"
+//	  scheme: https
+//	  # a duration, or seconds
+//	  timeout: 30s
+//	  connect_timeout: 10s
+//	  retry:
+//	    max_attempts: 3
+//	    min_backoff: 100ms
+//	    max_backoff: 5s
+//	    jitter: 0.2
+//	  endpoints:
+//	    v0.api.upyun.com: [v1.api.upyun.com, v2.api.upyun.com]
+//
+// The environment variables are UPYUN_BUCKET, UPYUN_USERNAME,
+// UPYUN_PASSWORD, UPYUN_PASSWORD_MD5, UPYUN_SCHEME, UPYUN_TIMEOUT,
+// UPYUN_CONNECT_TIMEOUT, UPYUN_RETRY_MAX_ATTEMPTS, UPYUN_RETRY_MIN_BACKOFF,
+// UPYUN_RETRY_MAX_BACKOFF, UPYUN_RETRY_JITTER and UPYUN_ENDPOINTS, a comma
+// separated list of endpoints of v0.api.upyun.com.
+func LoadConfig(file, profile string) (*UpYunConfig, error) {
+	c := &UpYunConfig{}
+	if file == "" {
+		file = os.Getenv(DefaultConfigFileEnv)
+	}
+	if profile == "" {
+		profile = orDefault(os.Getenv(DefaultProfileEnv), DefaultProfile)
+	}
+
+	source := ""
+	if file != "" {
+		source = fmt.Sprintf("%s [%s]", file, profile)
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		profiles, err := parseProfiles(b)
+		if err != nil {
+			if cerr, ok := err.(*ConfigError); ok {
+				// the field is prefixed with its profile
+				cerr.Source = file
+				if i := strings.Index(cerr.Field, "."); i > 0 {
+					cerr.Source = fmt.Sprintf("%s [%s]", file, cerr.Field[:i])
+					cerr.Field = cerr.Field[i+1:]
+				}
+				return nil, cerr
+			}
+			return nil, fmt.Errorf("upyun config: parse %s: %w", file, err)
+		}
+		p, ok := profiles[profile].(map[string]interface{})
+		if !ok {
+			return nil, &ConfigError{Source: file, Field: "profile", Reason: fmt.Sprintf("%q not found", profile)}
+		}
+		if err := applyProfile(c, source, p); err != nil {
+			return nil, err
+		}
+	}
+
+	envSources, err := applyEnv(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		// tell where the invalid field came from
+		if cerr, ok := err.(*ConfigError); ok {
+			cerr.Source = source
+			for field, env := range envSources {
+				if cerr.Field == field || strings.HasPrefix(cerr.Field, field+".") {
+					cerr.Source = env
+				}
+			}
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func applyProfile(c *UpYunConfig, source string, m map[string]interface{}) error {
+	for _, key := range profileKeys(m) {
+		v := m[key]
+		var err error
+		switch key {
+		case "bucket":
+			c.Bucket, err = configString(v)
+		case "operator":
+			c.Operator, err = configString(v)
+		case "password":
+			c.Password, err = configString(v)
+		case "password_md5":
+			c.PasswordMD5, err = configString(v)
+		case "scheme":
+			c.Scheme, err = configString(v)
+		case "user_agent":
+			c.UserAgent, err = configString(v)
+		case "timeout":
+			c.Timeout, err = configDuration(v)
+		case "connect_timeout":
+			c.ConnectTimeout, err = configDuration(v)
+		case "endpoints":
+			c.Endpoints, err = configEndpoints(v)
+		case "retry":
+			r, ok := v.(map[string]interface{})
+			if !ok {
+				err = errors.New("must be a mapping")
+				break
+			}
+			for _, k := range profileKeys(r) {
+				if err := applyRetry(c, k, r[k]); err != nil {
+					return &ConfigError{Source: source, Field: "retry." + k, Reason: err.Error()}
+				}
+			}
+		default:
+			err = errors.New("unknown field")
+		}
+		if err != nil {
+			return &ConfigError{Source: source, Field: key, Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+func applyRetry(c *UpYunConfig, key string, v interface{}) error {
+	if c.RetryPolicy == nil {
+		p := *DefaultRetryPolicy
+		c.RetryPolicy = &p
+	}
+	s, err := configString(v)
+	if err != nil {
+		return err
+	}
+	switch key {
+	case "max_attempts":
+		c.RetryPolicy.MaxAttempts, err = strconv.Atoi(s)
+	case "min_backoff":
+		c.RetryPolicy.MinBackoff, err = configDuration(s)
+	case "max_backoff":
+		c.RetryPolicy.MaxBackoff, err = configDuration(s)
+	case "jitter":
+		c.RetryPolicy.Jitter, err = strconv.ParseFloat(s, 64)
+	default:
+		return errors.New("unknown field")
+	}
+	if ne, ok := err.(*strconv.NumError); ok {
+		return fmt.Errorf("%q is not a number", ne.Num)
+	}
+	return err
+}
+
+// applyEnv returns the fields it has set, with the environment variable
+// each of them came from.
+func applyEnv(c *UpYunConfig) (map[string]string, error) {
+	envs := []struct {
+		name  string
+		field string
+		apply func(string) error
+	}{
+		{DefaultBucketEnv, "bucket", func(s string) error { c.Bucket = s; return nil }},
+		{DefaultOperatorEnv, "operator", func(s string) error { c.Operator = s; return nil }},
+		// either password overrides both of the profile
+		{DefaultPasswordEnv, "password", func(s string) error { c.Password, c.PasswordMD5 = s, ""; return nil }},
+		{DefaultPasswordMD5Env, "password_md5", func(s string) error { c.PasswordMD5, c.Password = s, ""; return nil }},
+		{"UPYUN_SCHEME", "scheme", func(s string) error { c.Scheme = s; return nil }},
+		{"UPYUN_TIMEOUT", "timeout", func(s string) (err error) {
+			c.Timeout, err = configDuration(s)
+			return
+		}},
+		{"UPYUN_CONNECT_TIMEOUT", "connect_timeout", func(s string) (err error) {
+			c.ConnectTimeout, err = configDuration(s)
+			return
+		}},
+		{"UPYUN_RETRY_MAX_ATTEMPTS", "retry.max_attempts", func(s string) error { return applyRetry(c, "max_attempts", s) }},
+		{"UPYUN_RETRY_MIN_BACKOFF", "retry.min_backoff", func(s string) error { return applyRetry(c, "min_backoff", s) }},
+		{"UPYUN_RETRY_MAX_BACKOFF", "retry.max_backoff", func(s string) error { return applyRetry(c, "max_backoff", s) }},
+		{"UPYUN_RETRY_JITTER", "retry.jitter", func(s string) error { return applyRetry(c, "jitter", s) }},
+		{"UPYUN_ENDPOINTS", "endpoints", func(s string) error {
+			c.Endpoints = map[string][]string{"v0.api.upyun.com": splitList(s)}
+			return nil
+		}},
+	}
+	sources := make(map[string]string)
+	for _, env := range envs {
+		s, ok := os.LookupEnv(env.name)
+		if !ok || s == "" {
+			continue
+		}
+		if err := env.apply(s); err != nil {
+			return nil, &ConfigError{Source: "env " + env.name, Field: env.field, Reason: err.Error()}
+		}
+		sources[env.field] = "env " + env.name
+	}
+	return sources, nil
+}
+
+func profileKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func configString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", errors.New("must be a scalar value")
+}
+
+// configDuration accepts a duration like "1m30s" or a number of seconds.
+func configDuration(v interface{}) (time.Duration, error) {
+	s, err := configString(v)
+	if err != nil {
+		return 0, err
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration", s)
+	}
+	return d, nil
+}
+
+// configEndpoints accepts a mapping of host to endpoints, or a list of
+// endpoints of v0.api.upyun.com.
+func configEndpoints(v interface{}) (map[string][]string, error) {
+	if l, ok := v.([]interface{}); ok {
+		v = map[string]interface{}{"v0.api.upyun.com": l}
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("must be a mapping or a list")
+	}
+	endpoints := make(map[string][]string, len(m))
+	for host, v := range m {
+		l, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("endpoints of %s must be a list", host)
+		}
+		for _, v := range l {
+			addr, err := configString(v)
+			if err != nil {
+				return nil, fmt.Errorf("endpoints of %s: %v", host, err)
+			}
+			endpoints[host] = append(endpoints[host], addr)
+		}
+	}
+	return endpoints, nil
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			l = append(l, item)
+		}
+	}
+	return l
+}
+
+// parseProfiles parses JSON, or the YAML-like format if b does not start
+// with '{'. In the latter a value containing '#' must be quoted, comments
+// take lines of their own; an invalid value is a *ConfigError.
+func parseProfiles(b []byte) (map[string]interface{}, error) {
+	if s := strings.TrimSpace(string(b)); strings.HasPrefix(s, "{") {
+		var m map[string]interface{}
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		if err := dec.Decode(&m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	var lines []yamlLine
+	for i, text := range strings.Split(string(b), "\n") {
+		text = strings.TrimRight(text, " \r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", i+1)
+		}
+		lines = append(lines, yamlLine{no: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	v, rest, err := parseYAMLBlock(lines, lines[0].indent, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("line %d: unexpected indentation", rest[0].no)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("line %d: profiles must be a mapping", lines[0].no)
+	}
+	return m, nil
+}
+
+type yamlLine struct {
+	no     int
+	indent int
+	text   string
+}
+
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseYAMLBlock parses the lines at indent into a mapping or a list of
+// scalars and returns the remaining lines, field is the dotted path of the
+// block.
+func parseYAMLBlock(lines []yamlLine, indent int, field string) (interface{}, []yamlLine, error) {
+	if isYAMLListItem(lines[0].text) {
+		var l []interface{}
+		for len(lines) > 0 && lines[0].indent == indent && isYAMLListItem(lines[0].text) {
+			item, err := yamlScalar(strings.TrimPrefix(lines[0].text, "-"))
+			if err != nil {
+				return nil, nil, yamlError(field, lines[0].no, err)
+			}
+			l = append(l, item)
+			lines = lines[1:]
+		}
+		return l, lines, nil
+	}
+
+	m := make(map[string]interface{})
+	for len(lines) > 0 && lines[0].indent == indent {
+		line := lines[0]
+		lines = lines[1:]
+		i := strings.Index(line.text, ":")
+		if i <= 0 || (i+1 < len(line.text) && line.text[i+1] != ' ') {
+			return nil, nil, fmt.Errorf("line %d: expect \"key: value\"", line.no)
+		}
+		key, value := strings.TrimSpace(line.text[:i]), strings.TrimSpace(line.text[i+1:])
+		if _, ok := m[key]; ok {
+			return nil, nil, fmt.Errorf("line %d: duplicated key %q", line.no, key)
+		}
+
+		path := key
+		if field != "" {
+			path = field + "." + key
+		}
+		switch {
+		case value != "" && !strings.HasPrefix(value, "#"):
+			v, err := yamlValue(value)
+			if err != nil {
+				return nil, nil, yamlError(path, line.no, err)
+			}
+			m[key] = v
+		case len(lines) > 0 && (lines[0].indent > indent ||
+			lines[0].indent == indent && isYAMLListItem(lines[0].text)):
+			v, rest, err := parseYAMLBlock(lines, lines[0].indent, path)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key], lines = v, rest
+		default:
+			m[key] = ""
+		}
+	}
+	if len(lines) > 0 && lines[0].indent > indent {
+		return nil, nil, fmt.Errorf("line %d: unexpected indentation", lines[0].no)
+	}
+	return m, lines, nil
+}
+
+// yamlValue parses a scalar or an inline list like [a, "b"].
+func yamlValue(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "[") {
+		return yamlScalar(s)
+	}
+	end := strings.LastIndex(s, "]")
+	if end < 0 {
+		return nil, errors.New("unterminated list")
+	}
+	l := []interface{}{}
+	for _, item := range strings.Split(s[1:end], ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		v, err := yamlScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+	return l, nil
+}
+
+func yamlError(field string, line int, err error) error {
+	return &ConfigError{Field: field, Reason: fmt.Sprintf("line %d: %v", line, err)}
+}
+
+// yamlScalar returns the unquoted string. An unquoted string must not
+// contain '#', so that a value like abc #1 is not taken for abc and a
+// comment.
+func yamlScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s != "" && (s[0] == '"' || s[0] == '\'') {
+		for end := 1; end < len(s); end++ {
+			switch {
+			case s[0] == '"' && s[end] == '\\':
+				end++
+			case s[end] == s[0] && s[0] == '"':
+				return strconv.Unquote(s[:end+1])
+			case s[end] == s[0]:
+				return s[1:end], nil
+			}
+		}
+		return "", errors.New("unterminated quoted string")
+	}
+	if strings.Contains(s, "#") {
+		return "", errors.New(`unquoted value contains "#", quote it`)
+	}
+	return s, nil
+}
@@ -0,0 +1,193 @@
+package upyun
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "upyun-config")
+	Nil(t, err)
+	file := filepath.Join(dir, name)
+	Nil(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func setenv(t *testing.T, kv ...string) func() {
+	for i := 0; i < len(kv); i += 2 {
+		Nil(t, os.Setenv(kv[i], kv[i+1]))
+	}
+	return func() {
+		for i := 0; i < len(kv); i += 2 {
+			os.Unsetenv(kv[i])
+		}
+	}
+}
+
+const testProfiles = `
+# comments are ignored
+default:
+  bucket: bucket
+  operator: operator
+  password: "pass # word"
+  timeout: 30s
+  retry:
+    max_attempts: 5
+    # seconds
+    max_backoff: 2
+  endpoints:
+    v0.api.upyun.com: [v1.api.upyun.com, 'v2.api.upyun.com']
+    p0.api.upyun.com:
+      - 127.0.0.1:8080
+
+other:
+  bucket: other
+  operator: operator
+  password_md5: 5f4dcc3b5aa765d61d8327deb882cf99
+  scheme: http
+`
+
+func TestLoadConfigYAML(t *testing.T) {
+	file := writeConfigFile(t, "upyun.yaml", testProfiles)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	c, err := LoadConfig(file, "")
+	Nil(t, err)
+	Equal(t, c.Bucket, "bucket")
+	Equal(t, c.Password, "pass # word")
+	Equal(t, c.Timeout, 30*time.Second)
+	Equal(t, c.RetryPolicy.MaxAttempts, 5)
+	Equal(t, c.RetryPolicy.MinBackoff, DefaultRetryPolicy.MinBackoff)
+	Equal(t, c.RetryPolicy.MaxBackoff, 2*time.Second)
+	Equal(t, c.Endpoints, map[string][]string{
+		"v0.api.upyun.com": {"v1.api.upyun.com", "v2.api.upyun.com"},
+		"p0.api.upyun.com": {"127.0.0.1:8080"},
+	})
+
+	defer setenv(t, "UPYUN_PROFILE", "other", "UPYUN_BUCKET", "from-env")()
+	c, err = LoadConfig(file, "")
+	Nil(t, err)
+	Equal(t, c.Bucket, "from-env")
+	Equal(t, c.Scheme, "http")
+	Equal(t, c.PasswordMD5, "5f4dcc3b5aa765d61d8327deb882cf99")
+
+	// the password of the env replaces the md5 of the profile, and back
+	func() {
+		defer setenv(t, "UPYUN_PASSWORD", "env-password")()
+		c, err = LoadConfig(file, "")
+		Nil(t, err)
+		Equal(t, c.PasswordMD5, "")
+		Equal(t, NewUpYun(c).Password, md5Str("env-password"))
+	}()
+	defer setenv(t, "UPYUN_PROFILE", "default", "UPYUN_PASSWORD_MD5", "0123456789abcdef0123456789abcdef")()
+	c, err = LoadConfig(file, "")
+	Nil(t, err)
+	Equal(t, c.Password, "")
+	Equal(t, NewUpYun(c).Password, "0123456789abcdef0123456789abcdef")
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	file := writeConfigFile(t, "upyun.json", `{
+		"prod": {"bucket": "b", "operator": "o", "password": "p", "timeout": 1.5,
+			"endpoints": ["v3.api.upyun.com"], "retry": {"jitter": 0}}
+	}`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	c, err := LoadConfig(file, "prod")
+	Nil(t, err)
+	Equal(t, c.Timeout, 1500*time.Millisecond)
+	Equal(t, c.Endpoints["v0.api.upyun.com"], []string{"v3.api.upyun.com"})
+	Equal(t, c.RetryPolicy.Jitter, 0.0)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	file := writeConfigFile(t, "upyun.yaml", testProfiles+`
+bad:
+  bucket: bucket
+  operator: operator
+  password: password
+  retry:
+    max_attempts: three
+nobucket:
+  operator: operator
+  password: password
+`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	var cerr *ConfigError
+	_, err := LoadConfig(file, "bad")
+	Equal(t, errors.As(err, &cerr), true)
+	Equal(t, cerr.Field, "retry.max_attempts")
+	Equal(t, cerr.Source, file+" [bad]")
+
+	_, err = LoadConfig(file, "nobucket")
+	Equal(t, errors.As(err, &cerr), true)
+	Equal(t, cerr.Field, "bucket")
+	Equal(t, cerr.Source, file+" [nobucket]")
+
+	_, err = LoadConfig(file, "missing")
+	Equal(t, errors.As(err, &cerr), true)
+	Equal(t, cerr.Field, "profile")
+
+	func() {
+		defer setenv(t, "UPYUN_TIMEOUT", "soon")()
+		_, err = LoadConfig(file, "")
+		Equal(t, errors.As(err, &cerr), true)
+		Equal(t, cerr.Field, "timeout")
+		Equal(t, cerr.Source, "env UPYUN_TIMEOUT")
+	}()
+
+	func() {
+		defer setenv(t, "UPYUN_SCHEME", "ftp")()
+		_, err = LoadConfig(file, "")
+		Equal(t, errors.As(err, &cerr), true)
+		Equal(t, cerr.Field, "scheme")
+		Equal(t, cerr.Source, "env UPYUN_SCHEME")
+	}()
+
+	_, err = parseProfiles([]byte("a:\n  b: 1\n    c: 2\n"))
+	NotNil(t, err)
+
+	// not cut short at the '#'
+	file = writeConfigFile(t, "upyun.yaml", "default:\n  bucket: bucket\n  operator: operator\n  password: abc #1\n")
+	defer os.RemoveAll(filepath.Dir(file))
+	_, err = LoadConfig(file, "")
+	Equal(t, errors.As(err, &cerr), true)
+	Equal(t, cerr.Field, "password")
+	Equal(t, cerr.Source, file+" [default]")
+
+	_, err = parseProfiles([]byte("a:\n  b:\n    - x #1\n"))
+	Equal(t, errors.As(err, &cerr), true)
+	Equal(t, cerr.Field, "a.b")
+}
+
+func TestNewWithOptions(t *testing.T) {
+	_, err := New(WithCredentials("operator", "password"))
+	var cerr *ConfigError
+	Equal(t, errors.As(err, &cerr), true)
+	Equal(t, cerr.Field, "bucket")
+
+	_, err = New(WithBucket("bucket"), WithCredentials("operator", "password"),
+		WithEndpoints("v0.api.upyun.com", "a/b"))
+	Equal(t, errors.As(err, &cerr), true)
+	Equal(t, cerr.Field, "endpoints.v0.api.upyun.com")
+
+	logger := NewLogger(ioutil.Discard, LogLevelDebug)
+	up, err := New(
+		WithConfig(&UpYunConfig{Bucket: "bucket", Operator: "operator", Password: "password"}),
+		WithTimeout(10*time.Second),
+		WithScheme("HTTP"),
+		WithDeprecatedAPI(),
+		WithLogger(logger),
+	)
+	Nil(t, err)
+	Equal(t, up.Password, md5Str("password"))
+	Equal(t, up.Scheme, "http")
+	Equal(t, up.httpc.Timeout, 10*time.Second)
+	Equal(t, up.deprecated, true)
+	Equal(t, up.logger, logger)
+}
@@ -0,0 +1,167 @@
+package upyun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Credentials of an UpYun operator. PasswordMD5 is the md5 hex digest of
+// the operator password, the plaintext password is never needed for signing.
+type Credentials struct {
+	Operator    string
+	PasswordMD5 string
+}
+
+// CredentialsProvider is consulted every time a request is signed, so
+// rotated credentials are used without rebuilding the UpYun. A request
+// fails with the error of Credentials instead of being sent unsigned.
+type CredentialsProvider interface {
+	Credentials() (Credentials, error)
+}
+
+// StaticCredentials always provides the same credentials.
+type StaticCredentials Credentials
+
+func (c StaticCredentials) Credentials() (Credentials, error) {
+	return Credentials(c), nil
+}
+
+// NewStaticCredentials returns a provider for operator and its plaintext password.
+func NewStaticCredentials(operator, password string) StaticCredentials {
+	return StaticCredentials{Operator: operator, PasswordMD5: md5Str(password)}
+}
+
+const (
+	DefaultOperatorEnv    = "UPYUN_USERNAME"
+	DefaultPasswordEnv    = "UPYUN_PASSWORD"
+	DefaultPasswordMD5Env = "UPYUN_PASSWORD_MD5"
+)
+
+// EnvCredentials reads the credentials from environment variables on every
+// call. The md5 of the password is preferred over the plaintext one.
+// Empty names default to DefaultOperatorEnv, DefaultPasswordEnv and
+// DefaultPasswordMD5Env. A *ConfigError names the variable which is not set.
+type EnvCredentials struct {
+	OperatorEnv    string
+	PasswordEnv    string
+	PasswordMD5Env string
+}
+
+func (e EnvCredentials) Credentials() (Credentials, error) {
+	operatorEnv := orDefault(e.OperatorEnv, DefaultOperatorEnv)
+	passwordEnv := orDefault(e.PasswordEnv, DefaultPasswordEnv)
+	passwordMD5Env := orDefault(e.PasswordMD5Env, DefaultPasswordMD5Env)
+	c := Credentials{
+		Operator:    os.Getenv(operatorEnv),
+		PasswordMD5: os.Getenv(passwordMD5Env),
+	}
+	if c.Operator == "" {
+		return c, &ConfigError{Source: "env " + operatorEnv, Field: "operator", Reason: "not set"}
+	}
+	if c.PasswordMD5 == "" {
+		password := os.Getenv(passwordEnv)
+		if password == "" {
+			return c, &ConfigError{Source: "env " + passwordEnv, Field: "password",
+				Reason: fmt.Sprintf("not set, nor is %s", passwordMD5Env)}
+		}
+		c.PasswordMD5 = md5Str(password)
+	}
+	return c, nil
+}
+
+func orDefault(s, def string) string {
+	if s != "" {
+		return s
+	}
+	return def
+}
+
+// FileCredentials reads the credentials from a JSON file like
+//
+//	{"operator": "op", "password_md5": "..."}
+//
+// or with a plaintext "password", and reloads it when the file changes.
+type FileCredentials struct {
+	path     string
+	interval time.Duration
+
+	mu        sync.Mutex
+	cred      Credentials
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+// NewFileCredentials loads path, which is checked for changes at most once
+// per interval (1 second if interval <= 0). A failed reload keeps the last
+// loaded credentials.
+func NewFileCredentials(path string, interval time.Duration) (*FileCredentials, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	f := &FileCredentials{path: path, interval: interval}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileCredentials) Credentials() (Credentials, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.checkedAt) >= f.interval {
+		f.reloadLocked()
+	}
+	return f.cred, nil
+}
+
+func (f *FileCredentials) reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reloadLocked()
+}
+
+func (f *FileCredentials) reloadLocked() error {
+	f.checkedAt = time.Now()
+	fInfo, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if fInfo.ModTime().Equal(f.modTime) && fInfo.Size() == f.size {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var v struct {
+		Operator    string `json:"operator"`
+		Password    string `json:"password"`
+		PasswordMD5 string `json:"password_md5"`
+	}
+	if err = json.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("parse credentials file %s: %w", f.path, err)
+	}
+	if v.Operator == "" || (v.Password == "" && v.PasswordMD5 == "") {
+		return fmt.Errorf("parse credentials file %s: operator or password is missing", f.path)
+	}
+	if v.PasswordMD5 == "" {
+		v.PasswordMD5 = md5Str(v.Password)
+	}
+
+	f.cred = Credentials{Operator: v.Operator, PasswordMD5: v.PasswordMD5}
+	f.modTime, f.size = fInfo.ModTime(), fInfo.Size()
+	return nil
+}
+
+func (u *UpYun) credentials() (Credentials, error) {
+	if u.CredentialsProvider != nil {
+		return u.CredentialsProvider.Credentials()
+	}
+	return Credentials{Operator: u.Operator, PasswordMD5: u.Password}, nil
+}
@@ -0,0 +1,104 @@
+package upyun
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCredentialsProvider(t *testing.T) {
+	auth := &UnifiedAuthConfig{Method: "GET", Uri: "/bucket/a", DateStr: makeRFC1123Date(time.Now())}
+	legacy := NewUpYun(&UpYunConfig{Bucket: "bucket", Operator: "op", Password: "password"})
+	hashed := NewUpYun(&UpYunConfig{Bucket: "bucket", Operator: "op", PasswordMD5: md5Str("password")})
+	static := NewUpYun(&UpYunConfig{
+		Bucket:              "bucket",
+		CredentialsProvider: NewStaticCredentials("op", "password"),
+	})
+	Equal(t, hashed.MakeUnifiedAuth(auth), legacy.MakeUnifiedAuth(auth))
+	Equal(t, static.MakeUnifiedAuth(auth), legacy.MakeUnifiedAuth(auth))
+
+	os.Setenv("UPYUN_TEST_OPERATOR", "op")
+	os.Setenv("UPYUN_TEST_PASSWORD", "password")
+	defer os.Unsetenv("UPYUN_TEST_OPERATOR")
+	defer os.Unsetenv("UPYUN_TEST_PASSWORD")
+	env := NewUpYun(&UpYunConfig{
+		Bucket: "bucket",
+		CredentialsProvider: EnvCredentials{
+			OperatorEnv:    "UPYUN_TEST_OPERATOR",
+			PasswordEnv:    "UPYUN_TEST_PASSWORD",
+			PasswordMD5Env: "UPYUN_TEST_PASSWORD_MD5",
+		},
+	})
+	Equal(t, env.MakePurgeAuth(&PurgeAuthConfig{DateStr: auth.DateStr}),
+		legacy.MakePurgeAuth(&PurgeAuthConfig{DateStr: auth.DateStr}))
+}
+
+func TestFileCredentialsRotation(t *testing.T) {
+	fname := filepath.Join(TempLocalDir(t), "credentials.json")
+	defer os.RemoveAll(filepath.Dir(fname))
+	Nil(t, ioutil.WriteFile(fname, []byte(`{"operator":"op","password":"old"}`), 0600))
+
+	provider, err := NewFileCredentials(fname, time.Millisecond)
+	Nil(t, err)
+	cred, err := provider.Credentials()
+	Nil(t, err)
+	Equal(t, cred, Credentials{Operator: "op", PasswordMD5: md5Str("old")})
+
+	Nil(t, ioutil.WriteFile(fname, []byte(`{"operator":"op2","password_md5":"`+md5Str("new")+`"}`), 0600))
+	time.Sleep(10 * time.Millisecond)
+	cred, err = provider.Credentials()
+	Nil(t, err)
+	Equal(t, cred, Credentials{Operator: "op2", PasswordMD5: md5Str("new")})
+
+	// a broken file keeps the last credentials
+	Nil(t, ioutil.WriteFile(fname, []byte(`{`), 0600))
+	time.Sleep(10 * time.Millisecond)
+	cred, err = provider.Credentials()
+	Nil(t, err)
+	Equal(t, cred.Operator, "op2")
+}
+
+func TestEnvCredentialsMissing(t *testing.T) {
+	provider := EnvCredentials{
+		OperatorEnv:    "UPYUN_TEST_OPERATOR",
+		PasswordEnv:    "UPYUN_TEST_PASSWORD",
+		PasswordMD5Env: "UPYUN_TEST_PASSWORD_MD5",
+	}
+	os.Setenv("UPYUN_TEST_OPERATOR", "op")
+	defer os.Unsetenv("UPYUN_TEST_OPERATOR")
+	cred, err := provider.Credentials()
+	Equal(t, cred.PasswordMD5, "")
+	var cerr *ConfigError
+	Equal(t, errors.As(err, &cerr), true)
+	Equal(t, cerr.Field, "password")
+	Equal(t, strings.Contains(err.Error(), "UPYUN_TEST_PASSWORD"), true)
+	Equal(t, strings.Contains(err.Error(), "UPYUN_TEST_PASSWORD_MD5"), true)
+
+	_, err = New(WithBucket("bucket"), WithCredentialsProvider(provider))
+	Equal(t, errors.As(err, &cerr), true)
+	Equal(t, cerr.Field, "password")
+
+	// a provider set after the config is validated fails the request
+	var requests int32
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	})
+	fake.CredentialsProvider = provider
+	_, err = fake.Usage()
+	Equal(t, errors.As(err, &cerr), true)
+	_, err = fake.FormUpload(&FormUploadConfig{LocalPath: "credentials_test.go", SaveKey: "/a"})
+	Equal(t, errors.As(err, &cerr), true)
+	Equal(t, atomic.LoadInt32(&requests), int32(0))
+
+	os.Setenv("UPYUN_TEST_PASSWORD", "password")
+	defer os.Unsetenv("UPYUN_TEST_PASSWORD")
+	cred, err = provider.Credentials()
+	Nil(t, err)
+	Equal(t, cred, Credentials{Operator: "op", PasswordMD5: md5Str("password")})
+}
@@ -0,0 +1,405 @@
+package upyun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDownloadChunkSize   = 8 * 1024 * 1024
+	DefaultDownloadConcurrency = 4
+	DefaultDownloadChunkTries  = 3
+)
+
+// DownloadConfig provides a configuration to Download method.
+type DownloadConfig struct {
+	Path    string
+	Headers map[string]string
+	// LocalPath or WriterAt receives the object, LocalPath takes precedence.
+	// LocalPath is replaced only after the whole object is received and its
+	// md5 is verified.
+	LocalPath string
+	WriterAt  io.WriterAt
+	// ChunkSize is the size of each range, default DefaultDownloadChunkSize
+	ChunkSize int64
+	// Concurrency is the number of ranges downloaded at the same time,
+	// default DefaultDownloadConcurrency
+	Concurrency int
+	// MaxChunkTries is the number of requests for each range, default
+	// DefaultDownloadChunkTries. Only retryable errors are retried, with
+	// the backoff of RetryPolicy but regardless of its MaxAttempts.
+	MaxChunkTries int
+	// Resume keeps the progress in a state file next to LocalPath, named
+	// LocalPath + DownloadStateSuffix, so that an interrupted download
+	// continues with the missing ranges only. It starts over if the object
+	// has changed since. The object is written to LocalPath in place.
+	Resume bool
+	// PreserveModTime sets the mtime of LocalPath to the modified time of
+	// the object.
+	PreserveModTime bool
+	// Progress, if set, receives the progress of the download, at most
+	// one ProgressTransferred event per ProgressInterval. The ranges
+	// completed by an earlier interrupted download count as transferred.
+	Progress         ProgressListener
+	ProgressInterval time.Duration
+}
+
+// offsetWriter writes to w sequentially from off, and counts the bytes
+// written to progress.
+type offsetWriter struct {
+	w        io.WriterAt
+	off      int64
+	progress *progressTracker
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	o.progress.transferred(int64(n))
+	return n, err
+}
+
+func (up *UpYun) Download(config *DownloadConfig) (*FileInfo, error) {
+	return up.DownloadWithContext(context.Background(), config)
+}
+
+// DownloadWithContext gets the size of the object by GetInfo, then
+// downloads it in ranges concurrently. Failed ranges are retried on their
+// own, the first range which fails for good cancels the others. Every range
+// is requested with the ETag of GetInfo as If-Match, so the download fails
+// with ErrPreconditionFailed if the object is overwritten in the meantime.
+func (up *UpYun) DownloadWithContext(ctx context.Context, config *DownloadConfig) (fInfo *FileInfo, err error) {
+	ctx, span := up.startSpan(ctx, "download", "upyun.path", config.Path)
+	defer func() { span.End(err) }()
+	ctx, progress := startProgress(ctx, config.Progress, config.ProgressInterval, config.Path, -1)
+	defer func() { progress.finish(err) }()
+
+	fInfo, err = up.GetInfoWithContext(ctx, config.Path)
+	if err != nil {
+		return nil, err
+	}
+	if fInfo.IsDir {
+		return nil, fmt.Errorf("download %s: is a directory", config.Path)
+	}
+	span.SetAttributes("upyun.size", fInfo.Size)
+	progress.setTotal(fInfo.Size)
+
+	if config.Resume {
+		if config.LocalPath == "" {
+			return nil, errors.New("resume download: no LocalPath")
+		}
+		if err = up.resumeDownload(ctx, config, fInfo); err != nil {
+			return nil, err
+		}
+		return fInfo, nil
+	}
+
+	w := config.WriterAt
+	var fd *atomicFile
+	if config.LocalPath != "" {
+		if fd, err = createAtomicFile(config.LocalPath); err != nil {
+			return nil, errorOperation("create file", err)
+		}
+		defer fd.abort()
+		if err = fd.Truncate(fInfo.Size); err != nil {
+			return nil, errorOperation("truncate file", err)
+		}
+		w = fd
+	}
+	if w == nil {
+		return nil, errors.New("no writer")
+	}
+
+	chunks := splitChunks(fInfo.Size, config.ChunkSize)
+	if err = up.downloadChunks(ctx, config, fInfo, chunks, w, nil); err != nil {
+		return nil, err
+	}
+	if fd != nil {
+		if err = verifyFileMD5(fd.File, fInfo.MD5); err != nil {
+			return nil, errorOperation("download "+config.Path, err)
+		}
+		if err = up.commitLocalFile(fd, config.PreserveModTime, fInfo); err != nil {
+			return nil, err
+		}
+	}
+	return fInfo, nil
+}
+
+func verifyFileMD5(f *os.File, expected string) error {
+	if !md5HexRegexp.MatchString(expected) {
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum, err := md5File(f)
+	if err != nil {
+		return err
+	}
+	return verifyMD5(expected, sum)
+}
+
+func splitChunks(size, chunkSize int64) []ByteRange {
+	if chunkSize <= 0 {
+		chunkSize = DefaultDownloadChunkSize
+	}
+	var chunks []ByteRange
+	for off := int64(0); off < size; off += chunkSize {
+		length := chunkSize
+		if off+length > size {
+			length = size - off
+		}
+		chunks = append(chunks, ByteRange{Offset: off, Length: length})
+	}
+	return chunks
+}
+
+// downloadChunks downloads chunks of the object fInfo into w concurrently,
+// done is called after each chunk is written.
+func (up *UpYun) downloadChunks(ctx context.Context, config *DownloadConfig, fInfo *FileInfo,
+	chunks []ByteRange, w io.WriterAt, done func(ByteRange) error) error {
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultDownloadConcurrency
+	}
+	if concurrency > len(chunks) {
+		concurrency = len(chunks)
+	}
+
+	progress := progressFromContext(ctx)
+	ctx, cancel := context.WithCancel(withoutProgress(ctx))
+	defer cancel()
+
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	ch := make(chan ByteRange)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range ch {
+				err := up.downloadChunk(ctx, config, fInfo, chunk, w, progress)
+				if err == nil && done != nil {
+					err = done(chunk)
+				}
+				if err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+loop:
+	for _, chunk := range chunks {
+		select {
+		case ch <- chunk:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (up *UpYun) downloadChunk(ctx context.Context, config *DownloadConfig, fInfo *FileInfo, chunk ByteRange,
+	w io.WriterAt, progress *progressTracker) (err error) {
+	maxTries := config.MaxChunkTries
+	if maxTries <= 0 {
+		maxTries = DefaultDownloadChunkTries
+	}
+	for try := 1; ; try++ {
+		var got *FileInfo
+		cw := &offsetWriter{w: w, off: chunk.Offset, progress: progress}
+		got, err = up.getObject(ctx, &GetObjectConfig{
+			Path:       config.Path,
+			Headers:    config.Headers,
+			Writer:     cw,
+			Offset:     chunk.Offset,
+			Length:     chunk.Length,
+			Conditions: Conditions{IfMatch: fInfo.MD5},
+		}, 1)
+		if errors.Is(err, ErrPreconditionFailed) {
+			return fmt.Errorf("download %s: object changed: %w", config.Path, err)
+		}
+		if err == nil && got.Size >= 0 && got.Size != fInfo.Size {
+			return fmt.Errorf("download %s: object size changed from %d to %d", config.Path, fInfo.Size, got.Size)
+		}
+		if err == nil || try >= maxTries || !IsRetryable(err) {
+			return err
+		}
+		progress.transferred(chunk.Offset - cw.off)
+		progress.retry(-1, try, err)
+		if serr := sleepWithContext(ctx, up.retryPolicy().backoff(try, err)); serr != nil {
+			return serr
+		}
+	}
+}
+
+// DownloadStateSuffix is appended to LocalPath to name the state file of a
+// resumable download.
+const DownloadStateSuffix = ".upyun-download"
+
+// downloadState is the progress of a resumable download.
+type downloadState struct {
+	Path      string      `json:"path"`
+	Size      int64       `json:"size"`
+	MD5       string      `json:"md5"`
+	ModTime   int64       `json:"mod_time"`
+	Completed []ByteRange `json:"completed"`
+}
+
+// matches reports whether the state is of the same object as fInfo.
+func (s *downloadState) matches(path string, fInfo *FileInfo) bool {
+	return s.Path == path && s.Size == fInfo.Size && s.MD5 == fInfo.MD5 &&
+		s.ModTime == fInfo.Time.Unix()
+}
+
+// missing returns the ranges of the object which are not completed, split
+// into chunks.
+func (s *downloadState) missing(chunkSize int64) []ByteRange {
+	var chunks []ByteRange
+	pos := int64(0)
+	for _, r := range append(s.Completed, ByteRange{Offset: s.Size}) {
+		for _, c := range splitChunks(r.Offset-pos, chunkSize) {
+			c.Offset += pos
+			chunks = append(chunks, c)
+		}
+		if end := r.Offset + r.Length; end > pos {
+			pos = end
+		}
+	}
+	return chunks
+}
+
+// complete adds r to the completed ranges, which are kept sorted and merged.
+func (s *downloadState) complete(r ByteRange) {
+	ranges := append(s.Completed, r)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Offset < ranges[j].Offset })
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && merged[n-1].Offset+merged[n-1].Length >= r.Offset {
+			if end := r.Offset + r.Length; end > merged[n-1].Offset+merged[n-1].Length {
+				merged[n-1].Length = end - merged[n-1].Offset
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	s.Completed = merged
+}
+
+func loadDownloadState(name string) (*downloadState, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &downloadState{}
+	if err = json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// save writes the state to a temporary file first, so that the state file
+// is never left half written.
+func (s *downloadState) save(name string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (up *UpYun) resumeDownload(ctx context.Context, config *DownloadConfig, fInfo *FileInfo) (err error) {
+	stateFile := config.LocalPath + DownloadStateSuffix
+	state, lerr := loadDownloadState(stateFile)
+	if lerr != nil || !state.matches(config.Path, fInfo) {
+		if lerr == nil {
+			up.log().Info("upyun object changed, download starts over", "path", config.Path)
+		}
+		state = &downloadState{
+			Path:    config.Path,
+			Size:    fInfo.Size,
+			MD5:     fInfo.MD5,
+			ModTime: fInfo.Time.Unix(),
+		}
+	}
+
+	fd, err := os.OpenFile(config.LocalPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return errorOperation("open file", err)
+	}
+	defer func() {
+		if cerr := fd.Close(); err == nil && cerr != nil {
+			err = errorOperation("close file", cerr)
+		}
+	}()
+	if fsInfo, serr := fd.Stat(); serr != nil || fsInfo.Size() != fInfo.Size {
+		// the file is not what the state is about
+		state.Completed = nil
+		if err = fd.Truncate(fInfo.Size); err != nil {
+			return errorOperation("truncate file", err)
+		}
+	}
+	if err = state.save(stateFile); err != nil {
+		return errorOperation("save download state", err)
+	}
+
+	for _, r := range state.Completed {
+		progressFromContext(ctx).skip(r.Length)
+	}
+	var mu sync.Mutex
+	chunks := state.missing(config.ChunkSize)
+	err = up.downloadChunks(ctx, config, fInfo, chunks, fd, func(chunk ByteRange) error {
+		mu.Lock()
+		defer mu.Unlock()
+		// the data must be on disk before the state says so
+		if err := fd.Sync(); err != nil {
+			return errorOperation("sync file", err)
+		}
+		state.complete(chunk)
+		if err := state.save(stateFile); err != nil {
+			return errorOperation("save download state", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = verifyFileMD5(fd, fInfo.MD5); err != nil {
+		// start over next time
+		os.Remove(stateFile)
+		return errorOperation("download "+config.Path, err)
+	}
+	if config.PreserveModTime && !fInfo.Time.IsZero() {
+		if err = os.Chtimes(config.LocalPath, fInfo.Time, fInfo.Time); err != nil {
+			return errorOperation("chtimes", err)
+		}
+	}
+	return os.Remove(stateFile)
+}
@@ -0,0 +1,302 @@
+package upyun
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// memWriterAt is an in-memory io.WriterAt
+type memWriterAt struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (m *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if end := int(off) + len(p); end > len(m.buf) {
+		m.buf = append(m.buf, make([]byte, end-len(m.buf))...)
+	}
+	return copy(m.buf[off:], p), nil
+}
+
+func TestDownload(t *testing.T) {
+	bucket := newFakeBucket()
+	content := make([]byte, 1<<20+123)
+	rand.Read(content)
+	bucket.put("/media", content)
+
+	var mu sync.Mutex
+	cut := map[string]bool{}
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		rg := r.Header.Get("Range")
+		mu.Lock()
+		first := !cut[rg]
+		cut[rg] = true
+		mu.Unlock()
+		if rg != "" && first {
+			// cut the body of the first attempt of every range short
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %s/%d", strings.TrimPrefix(rg, "bytes="), len(content)))
+			w.Header().Set("Content-Length", "100")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("short"))
+			return
+		}
+		bucket.ServeHTTP(w, r)
+	})
+
+	fname := filepath.Join(t.TempDir(), "media")
+	fInfo, err := fake.Download(&DownloadConfig{
+		Path:        "/media",
+		LocalPath:   fname,
+		ChunkSize:   64 * 1024,
+		Concurrency: 4,
+	})
+	Nil(t, err)
+	Equal(t, fInfo.Size, int64(len(content)))
+	b, err := ioutil.ReadFile(fname)
+	Nil(t, err)
+	Equal(t, bytes.Equal(b, content), true)
+	// the HEAD request and every range
+	Equal(t, len(cut), 1+(len(content)+64*1024-1)/(64*1024))
+
+	var mem memWriterAt
+	_, err = fake.Download(&DownloadConfig{Path: "/media", WriterAt: &mem, ChunkSize: 300 * 1024})
+	Nil(t, err)
+	Equal(t, bytes.Equal(mem.buf, content), true)
+
+	_, err = fake.Download(&DownloadConfig{Path: "/missing", WriterAt: &mem})
+	Equal(t, IsNotExist(err), true)
+}
+
+func TestDownloadObjectChanged(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	bucket.put("/a", bytes.Repeat([]byte("a"), 1000))
+	fake.Use(func(next Handler) Handler {
+		return func(op string, req *http.Request) (*http.Response, error) {
+			if req.Method == "GET" {
+				bucket.put("/a", bytes.Repeat([]byte("b"), 2000))
+			}
+			return next(op, req)
+		}
+	})
+
+	_, err := fake.Download(&DownloadConfig{Path: "/a", WriterAt: &memWriterAt{}, ChunkSize: 100, MaxChunkTries: 1})
+	NotNil(t, err)
+}
+
+func TestDownloadObjectOverwritten(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	bucket.put("/a", bytes.Repeat([]byte("a"), 1000))
+	var gets int32
+	fake.Use(func(next Handler) Handler {
+		return func(op string, req *http.Request) (*http.Response, error) {
+			if req.Method == "GET" && atomic.AddInt32(&gets, 1) == 2 {
+				// same size, different content
+				bucket.put("/a", bytes.Repeat([]byte("b"), 1000))
+			}
+			return next(op, req)
+		}
+	})
+
+	mem := &memWriterAt{}
+	_, err := fake.Download(&DownloadConfig{Path: "/a", WriterAt: mem, ChunkSize: 100, Concurrency: 1})
+	Equal(t, errors.Is(err, ErrPreconditionFailed), true)
+	Equal(t, atomic.LoadInt32(&gets), int32(2))
+}
+
+func TestDownloadChunkTries(t *testing.T) {
+	bucket := newFakeBucket()
+	bucket.put("/a", bytes.Repeat([]byte("a"), 1000))
+	var gets int32
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bucket.ServeHTTP(w, r)
+	})
+
+	// MaxChunkTries is not multiplied by RetryPolicy.MaxAttempts
+	_, err := fake.Download(&DownloadConfig{Path: "/a", WriterAt: &memWriterAt{}, MaxChunkTries: 2})
+	Equal(t, errors.Is(err, ErrServer), true)
+	Equal(t, atomic.LoadInt32(&gets), int32(2))
+}
+
+func TestSplitChunks(t *testing.T) {
+	Equal(t, splitChunks(0, 10), []ByteRange(nil))
+	Equal(t, splitChunks(25, 10), []ByteRange{{0, 10}, {10, 10}, {20, 5}})
+	Equal(t, len(splitChunks(DefaultDownloadChunkSize+1, 0)), 2)
+	Equal(t, strconv.Itoa(len(splitChunks(100, 1))), "100")
+}
+
+func TestResumeDownload(t *testing.T) {
+	bucket := newFakeBucket()
+	content := make([]byte, 100*1024+7)
+	rand.Read(content)
+	bucket.put("/media", content)
+
+	var mu sync.Mutex
+	gets, failAfter := 0, 5
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			gets++
+			fail := failAfter > 0 && gets > failAfter
+			mu.Unlock()
+			if fail {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		bucket.ServeHTTP(w, r)
+	})
+
+	fname := filepath.Join(t.TempDir(), "media")
+	config := &DownloadConfig{
+		Path:        "/media",
+		LocalPath:   fname,
+		ChunkSize:   10 * 1024,
+		Concurrency: 1,
+		Resume:      true,
+	}
+	_, err := fake.Download(config)
+	NotNil(t, err)
+	state, err := loadDownloadState(fname + DownloadStateSuffix)
+	Nil(t, err)
+	Equal(t, state.Completed, []ByteRange{{0, 50 * 1024}})
+
+	// continue with the missing ranges only
+	mu.Lock()
+	gets, failAfter = 0, 0
+	mu.Unlock()
+	var rec progressRecorder
+	config.Progress = rec.listen
+	_, err = fake.Download(config)
+	Nil(t, err)
+	Equal(t, gets, 6)
+	// the completed ranges count as transferred
+	rec.check(t, ProgressCompleted, int64(len(content)), int64(len(content)))
+	Equal(t, rec.events[1].Type, ProgressTransferred)
+	Equal(t, rec.events[1].Transferred > 50*1024, true)
+	config.Progress = nil
+	b, _ := ioutil.ReadFile(fname)
+	Equal(t, bytes.Equal(b, content), true)
+	_, err = os.Stat(fname + DownloadStateSuffix)
+	Equal(t, os.IsNotExist(err), true)
+
+	// the object changed after an interrupted download, start over
+	Nil(t, (&downloadState{Path: "/media", Size: int64(len(content)), MD5: "stale",
+		Completed: []ByteRange{{0, 100 * 1024}}}).save(fname+DownloadStateSuffix))
+	gets = 0
+	_, err = fake.Download(config)
+	Nil(t, err)
+	Equal(t, gets, 11)
+}
+
+func TestDownloadStateMissing(t *testing.T) {
+	s := &downloadState{Size: 100}
+	s.complete(ByteRange{10, 10})
+	s.complete(ByteRange{40, 10})
+	s.complete(ByteRange{20, 5})
+	s.complete(ByteRange{0, 5})
+	Equal(t, s.Completed, []ByteRange{{0, 5}, {10, 15}, {40, 10}})
+	Equal(t, s.missing(10), []ByteRange{{5, 5}, {25, 10}, {35, 5}, {50, 10}, {60, 10}, {70, 10}, {80, 10}, {90, 10}})
+}
+
+// corruptWriter flips every byte of the response body
+type corruptWriter struct {
+	http.ResponseWriter
+}
+
+func (w corruptWriter) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	for i := range p {
+		b[i] = ^p[i]
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func TestDownloadMD5Mismatch(t *testing.T) {
+	bucket := newFakeBucket()
+	content := make([]byte, 200*1024)
+	rand.Read(content)
+	bucket.put("/media", content)
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		bucket.ServeHTTP(corruptWriter{w}, r)
+	})
+
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "media")
+	Nil(t, ioutil.WriteFile(fname, []byte("old"), 0644))
+
+	_, err := fake.Download(&DownloadConfig{Path: "/media", LocalPath: fname, ChunkSize: 64 * 1024})
+	Equal(t, errors.Is(err, ErrMD5Mismatch), true)
+	_, err = fake.Get(&GetObjectConfig{Path: "/media", LocalPath: fname})
+	Equal(t, errors.Is(err, ErrMD5Mismatch), true)
+
+	// the old file is left untouched and no temporary file is left behind
+	b, err := ioutil.ReadFile(fname)
+	Nil(t, err)
+	Equal(t, string(b), "old")
+	files, err := ioutil.ReadDir(dir)
+	Nil(t, err)
+	Equal(t, len(files), 1)
+
+	// a resumed download is written in place, its state is dropped so that
+	// the next attempt starts over
+	_, err = fake.Download(&DownloadConfig{Path: "/media", LocalPath: fname, ChunkSize: 64 * 1024, Resume: true})
+	Equal(t, errors.Is(err, ErrMD5Mismatch), true)
+	_, err = os.Stat(fname + DownloadStateSuffix)
+	Equal(t, os.IsNotExist(err), true)
+}
+
+func TestDownloadPreserveModTime(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	content := make([]byte, 100*1024)
+	rand.Read(content)
+	bucket.put("/media", content)
+
+	dir := t.TempDir()
+	for i, get := range []func(string) (*FileInfo, error){
+		func(name string) (*FileInfo, error) {
+			return fake.Get(&GetObjectConfig{Path: "/media", LocalPath: name, PreserveModTime: true})
+		},
+		func(name string) (*FileInfo, error) {
+			return fake.Download(&DownloadConfig{Path: "/media", LocalPath: name, ChunkSize: 32 * 1024, PreserveModTime: true})
+		},
+		func(name string) (*FileInfo, error) {
+			return fake.Download(&DownloadConfig{Path: "/media", LocalPath: name, Resume: true, PreserveModTime: true})
+		},
+	} {
+		fname := filepath.Join(dir, strconv.Itoa(i))
+		fInfo, err := get(fname)
+		Nil(t, err)
+		Equal(t, fInfo.Time.IsZero(), false)
+		fsInfo, err := os.Stat(fname)
+		Nil(t, err)
+		Equal(t, fsInfo.ModTime().Equal(fInfo.Time), true)
+		b, err := ioutil.ReadFile(fname)
+		Nil(t, err)
+		Equal(t, bytes.Equal(b, content), true)
+	}
+
+	// a failed get leaves nothing behind
+	_, err := fake.Get(&GetObjectConfig{Path: "/missing", LocalPath: filepath.Join(dir, "missing")})
+	Equal(t, IsNotExist(err), true)
+	files, err := ioutil.ReadDir(dir)
+	Nil(t, err)
+	Equal(t, len(files), 3)
+}
@@ -0,0 +1,214 @@
+package upyun
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker controls when an endpoint is taken out of rotation.
+type CircuitBreaker struct {
+	// MaxFailures is the number of consecutive connection failures after
+	// which the endpoint is skipped.
+	MaxFailures int
+	// Cooldown is how long a broken endpoint is skipped before it is tried
+	// again.
+	Cooldown time.Duration
+}
+
+// DefaultCircuitBreaker is used when UpYunConfig.CircuitBreaker is nil.
+var DefaultCircuitBreaker = &CircuitBreaker{
+	MaxFailures: 3,
+	Cooldown:    30 * time.Second,
+}
+
+// EndpointStatus is the health of an endpoint.
+type EndpointStatus struct {
+	Addr string
+	// ConsecutiveFailures counts the connection failures since the last
+	// success.
+	ConsecutiveFailures int
+	// BrokenUntil is non-zero while the endpoint is skipped.
+	BrokenUntil time.Time
+}
+
+type endpoint struct {
+	addr        string
+	failures    int
+	brokenUntil time.Time
+}
+
+// endpointGroup is the ordered list of endpoints serving one logical host.
+type endpointGroup struct {
+	breaker *CircuitBreaker
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+}
+
+func newEndpointGroup(addrs []string, breaker *CircuitBreaker) *endpointGroup {
+	g := &endpointGroup{breaker: breaker}
+	for _, addr := range addrs {
+		g.endpoints = append(g.endpoints, &endpoint{addr: addr})
+	}
+	return g
+}
+
+// pick returns the first healthy endpoint which is not in skip. If all of
+// them are broken, the one which recovers first is returned.
+func (g *endpointGroup) pick(skip map[string]bool) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	var fallback *endpoint
+	for _, e := range g.endpoints {
+		if skip[e.addr] {
+			continue
+		}
+		if !now.Before(e.brokenUntil) {
+			return e.addr
+		}
+		if fallback == nil || e.brokenUntil.Before(fallback.brokenUntil) {
+			fallback = e
+		}
+	}
+	if fallback != nil {
+		return fallback.addr
+	}
+	return g.endpoints[0].addr
+}
+
+// hasHealthy reports whether there is a healthy endpoint not in skip.
+func (g *endpointGroup) hasHealthy(skip map[string]bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for _, e := range g.endpoints {
+		if !skip[e.addr] && !now.Before(e.brokenUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *endpointGroup) report(addr string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, e := range g.endpoints {
+		if e.addr != addr {
+			continue
+		}
+		if !isConnectionError(err) {
+			e.failures, e.brokenUntil = 0, time.Time{}
+			return
+		}
+		e.failures++
+		if e.failures >= g.breaker.MaxFailures {
+			e.brokenUntil = time.Now().Add(g.breaker.Cooldown)
+		}
+		return
+	}
+}
+
+func (g *endpointGroup) hasAll(addrs []string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, addr := range addrs {
+		found := false
+		for _, e := range g.endpoints {
+			found = found || e.addr == addr
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// reorder moves the endpoints in order to the front, in that order,
+// keeping their health.
+func (g *endpointGroup) reorder(order []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	rank := make(map[string]int, len(order))
+	for i, addr := range order {
+		if _, ok := rank[addr]; !ok {
+			rank[addr] = i
+		}
+	}
+	sort.SliceStable(g.endpoints, func(i, j int) bool {
+		ri, oki := rank[g.endpoints[i].addr]
+		rj, okj := rank[g.endpoints[j].addr]
+		if oki != okj {
+			return oki
+		}
+		return ri < rj
+	})
+}
+
+func (g *endpointGroup) status() []EndpointStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	res := make([]EndpointStatus, len(g.endpoints))
+	for i, e := range g.endpoints {
+		res[i] = EndpointStatus{Addr: e.addr, ConsecutiveFailures: e.failures, BrokenUntil: e.brokenUntil}
+	}
+	return res
+}
+
+// isConnectionError reports whether err means the endpoint could not be
+// reached, as opposed to an error response or a canceled request.
+func isConnectionError(err error) bool {
+	var ae *Error
+	if errors.As(err, &ae) || !IsRetryable(err) {
+		return false
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr)
+}
+
+func isIdempotent(method string) bool {
+	return method != "POST" && method != "PATCH"
+}
+
+func (up *UpYun) endpointGroup(host string) *endpointGroup {
+	up.endpointMu.RLock()
+	g := up.endpointGroups[host]
+	up.endpointMu.RUnlock()
+	if g != nil {
+		return g
+	}
+	// Hosts can be changed at any time, so it is not cached
+	return newEndpointGroup([]string{up.legacyEndpoint(host)}, up.circuitBreaker())
+}
+
+// requestHost returns the Host of a request for the logical host sent to
+// endpoint. A named access point such as v1.api.upyun.com serves under its
+// own name, while an ip or a proxy is sent the logical host.
+func requestHost(host, endpoint string) string {
+	name := endpoint
+	if h, _, err := net.SplitHostPort(endpoint); err == nil {
+		name = h
+	}
+	if strings.HasSuffix(name, ".upyun.com") {
+		return name
+	}
+	return host
+}
+
+func (up *UpYun) circuitBreaker() *CircuitBreaker {
+	if up.CircuitBreaker != nil {
+		return up.CircuitBreaker
+	}
+	return DefaultCircuitBreaker
+}
+
+// EndpointStatus returns the health of the endpoints configured for the
+// logical host, e.g. "v0.api.upyun.com".
+func (up *UpYun) EndpointStatus(host string) []EndpointStatus {
+	return up.endpointGroup(host).status()
+}
@@ -0,0 +1,208 @@
+package upyun
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Nil(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestEndpointFailover(t *testing.T) {
+	var hosts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		w.Write([]byte("1"))
+	}))
+	defer srv.Close()
+
+	dead := deadAddr(t)
+	fake := NewUpYun(&UpYunConfig{
+		Bucket:   "bucket",
+		Operator: "operator",
+		Password: "password",
+		Scheme:   "http",
+		Endpoints: map[string][]string{
+			"v0.api.upyun.com": {dead, srv.Listener.Addr().String()},
+		},
+		RetryPolicy:    &RetryPolicy{MaxAttempts: 1},
+		CircuitBreaker: &CircuitBreaker{MaxFailures: 2, Cooldown: time.Minute},
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := fake.Usage()
+		Nil(t, err)
+	}
+	Equal(t, hosts, []string{"v0.api.upyun.com", "v0.api.upyun.com", "v0.api.upyun.com"})
+
+	status := fake.EndpointStatus("v0.api.upyun.com")
+	Equal(t, status[0].Addr, dead)
+	// the third request skips the broken endpoint
+	Equal(t, status[0].ConsecutiveFailures, 2)
+	Equal(t, status[0].BrokenUntil.After(time.Now()), true)
+	Equal(t, status[1].ConsecutiveFailures, 0)
+	Equal(t, fake.doGetEndpoint("v0.api.upyun.com"), srv.Listener.Addr().String())
+}
+
+func TestEndpointNoFailoverForPost(t *testing.T) {
+	var tries int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tries, 1)
+	}))
+	defer srv.Close()
+
+	fake := NewUpYun(&UpYunConfig{
+		Bucket: "bucket",
+		Scheme: "http",
+		Endpoints: map[string][]string{
+			"v0.api.upyun.com": {deadAddr(t), srv.Listener.Addr().String()},
+		},
+	})
+	err := fake.Mkdir("/a")
+	NotNil(t, err)
+	Equal(t, atomic.LoadInt32(&tries), int32(0))
+}
+
+func TestEndpointProber(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+
+	dead := deadAddr(t)
+	fake := NewUpYun(&UpYunConfig{
+		Bucket:   "bucket",
+		Operator: "operator",
+		Password: "password",
+		Scheme:   "http",
+	})
+	candidates := []string{dead, slow.Listener.Addr().String(), fast.Listener.Addr().String()}
+	p := fake.StartEndpointProber(&EndpointProberConfig{
+		Candidates: candidates,
+		Interval:   -1,
+	})
+	defer p.Stop()
+
+	results := p.Results()
+	Equal(t, len(results), 3)
+	Equal(t, results[0].Addr, fast.Listener.Addr().String())
+	Equal(t, results[1].Addr, slow.Listener.Addr().String())
+	Equal(t, results[2].Addr, dead)
+	NotNil(t, results[2].Err)
+	Equal(t, fake.doGetEndpoint("v0.api.upyun.com"), fast.Listener.Addr().String())
+
+	Nil(t, p.Pin(slow.Listener.Addr().String()))
+	Equal(t, fake.doGetEndpoint("v0.api.upyun.com"), slow.Listener.Addr().String())
+	// an unknown endpoint leaves the order alone
+	NotNil(t, p.Pin("v9.api.upyun.com"))
+	Equal(t, fake.doGetEndpoint("v0.api.upyun.com"), slow.Listener.Addr().String())
+	Nil(t, p.Pin(""))
+	Equal(t, fake.doGetEndpoint("v0.api.upyun.com"), fast.Listener.Addr().String())
+}
+
+func TestEndpointProberPeriodic(t *testing.T) {
+	var rounds int32
+	latency := map[string]time.Duration{"a": 1, "b": 2}
+	fake := NewUpYun(&UpYunConfig{Bucket: "bucket", Operator: "operator", Password: "password"})
+	p := fake.StartEndpointProber(&EndpointProberConfig{
+		Candidates: []string{"a", "b"},
+		Interval:   10 * time.Millisecond,
+		Probe: func(ctx context.Context, addr string) (time.Duration, error) {
+			if addr == "a" && atomic.AddInt32(&rounds, 1) > 1 {
+				return 0, errors.New("down")
+			}
+			return latency[addr], nil
+		},
+	})
+	Equal(t, fake.doGetEndpoint("v0.api.upyun.com"), "a")
+
+	deadline := time.Now().Add(time.Second)
+	for fake.doGetEndpoint("v0.api.upyun.com") != "b" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	p.Stop()
+	Equal(t, fake.doGetEndpoint("v0.api.upyun.com"), "b")
+}
+
+func TestEndpointProberThroughput(t *testing.T) {
+	var mu sync.Mutex
+	var hosts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.Method+" "+r.Host)
+		mu.Unlock()
+		switch {
+		case r.Method == "HEAD" && r.Host == "v1.api.upyun.com":
+			// v1 answers first, but sends slowly
+		case r.Method == "HEAD":
+			time.Sleep(20 * time.Millisecond)
+		case r.URL.Path == "/bucket/probe":
+			Equal(t, r.Header.Get("Range"), "bytes=0-1023")
+			for i := 0; i < 4; i++ {
+				w.Write(make([]byte, 256))
+				w.(http.Flusher).Flush()
+				if r.Host == "v1.api.upyun.com" {
+					time.Sleep(20 * time.Millisecond)
+				}
+			}
+		default:
+			w.Write([]byte("1"))
+		}
+	}))
+	defer srv.Close()
+
+	fake := NewUpYun(&UpYunConfig{
+		Bucket:   "bucket",
+		Operator: "operator",
+		Password: "password",
+		Scheme:   "http",
+	})
+	// every access point is served by srv
+	fake.SetHTTPClient(&http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}})
+	p := fake.StartEndpointProber(&EndpointProberConfig{
+		Candidates:  []string{"v1.api.upyun.com", "v2.api.upyun.com"},
+		Interval:    -1,
+		ProbeObject: "/probe",
+		ProbeBytes:  1024,
+	})
+	defer p.Stop()
+
+	results := p.Results()
+	Equal(t, results[0].Addr, "v2.api.upyun.com")
+	Equal(t, results[0].Latency > results[1].Latency, true)
+	Equal(t, results[0].Throughput > results[1].Throughput, true)
+
+	// requests are sent to the access point under its own name
+	mu.Lock()
+	hosts = nil
+	mu.Unlock()
+	_, err := fake.Usage()
+	Nil(t, err)
+	Equal(t, hosts, []string{"GET v2.api.upyun.com"})
+}
+
+func TestRequestHost(t *testing.T) {
+	Equal(t, requestHost("v0.api.upyun.com", "v0.api.upyun.com"), "v0.api.upyun.com")
+	Equal(t, requestHost("v0.api.upyun.com", "v3.api.upyun.com"), "v3.api.upyun.com")
+	Equal(t, requestHost("v0.api.upyun.com", "v3.api.upyun.com:80"), "v3.api.upyun.com")
+	Equal(t, requestHost("v0.api.upyun.com", "127.0.0.1:8080"), "v0.api.upyun.com")
+	Equal(t, requestHost("v0.api.upyun.com", "proxy.example.com"), "v0.api.upyun.com")
+}
@@ -1,13 +1,68 @@
 package upyun
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 )
 
+// UpYun error codes, the Code of Error. See the full list at
+// https://help.upyun.com/knowledge-base/errno/
+const (
+	ErrCodeMD5Mismatch         = 40000006
+	ErrCodeNeedDate            = 40100001
+	ErrCodeDateOffset          = 40100002
+	ErrCodeNeedAuthorization   = 40100003
+	ErrCodeAuthorizationFormat = 40100004
+	ErrCodeSignature           = 40100005
+	ErrCodeUserNotExist        = 40100006
+	ErrCodeBucketNotExist      = 40100012
+	ErrCodeNoDeletePermission  = 40300011
+	ErrCodeNotFound            = 40400001
+	ErrCodeFolderNotEmpty      = 40600002
+	ErrCodeFileTooLarge        = 41300001
+	ErrCodeTooManyRequests     = 42900001
+)
+
+// Sentinel errors matched by errors.Is against the status code of Error,
+// e.g. errors.Is(err, ErrNotFound).
+var (
+	ErrNotModified        = errors.New("upyun: not modified")
+	ErrBadRequest         = errors.New("upyun: bad request")
+	ErrUnauthorized       = errors.New("upyun: unauthorized")
+	ErrForbidden          = errors.New("upyun: forbidden")
+	ErrNotFound           = errors.New("upyun: not found")
+	ErrConflict           = errors.New("upyun: conflict")
+	ErrPreconditionFailed = errors.New("upyun: precondition failed")
+	ErrTooLarge           = errors.New("upyun: request entity too large")
+	ErrTooManyRequests    = errors.New("upyun: too many requests")
+	ErrServer             = errors.New("upyun: server error")
+
+	// ErrMD5Mismatch means the content does not match its md5 digest, e.g.
+	// a downloaded object, or a file changed before ResumePut resumed it.
+	ErrMD5Mismatch = errors.New("upyun: md5 mismatch")
+)
+
+var statusSentinels = map[int]error{
+	http.StatusNotModified:           ErrNotModified,
+	http.StatusBadRequest:            ErrBadRequest,
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusConflict:              ErrConflict,
+	http.StatusPreconditionFailed:    ErrPreconditionFailed,
+	http.StatusRequestEntityTooLarge: ErrTooLarge,
+	http.StatusTooManyRequests:       ErrTooManyRequests,
+}
+
 type Error struct {
 	Code       int    `json:"code"`
 	Message    string `json:"msg"`
@@ -16,15 +71,37 @@ type Error struct {
 	StatusCode int
 	Header     http.Header
 	Body       []byte
+	// Err is the error reading the response body, if any
+	Err error `json:"-"`
 }
 
 func (e *Error) Error() string {
-	if e.Operation == "" {
-		e.Operation = "upyun api"
+	op := e.Operation
+	if op == "" {
+		op = "upyun api"
 	}
 
 	return fmt.Sprintf("%s error: status=%d, code=%d, message=%s, request-id=%s",
-		e.Operation, e.StatusCode, e.Code, e.Message, e.RequestID)
+		op, e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// Is matches the sentinel of the status code, or an *Error target with
+// the same non-zero Code, e.g. errors.Is(err, &Error{Code: ErrCodeSignature}).
+func (e *Error) Is(target error) bool {
+	if t, ok := target.(*Error); ok {
+		return t.Code != 0 && t.Code == e.Code
+	}
+	if target == ErrServer {
+		return e.StatusCode >= 500
+	}
+	if target == ErrMD5Mismatch {
+		return e.Code == ErrCodeMD5Mismatch
+	}
+	return target != nil && statusSentinels[e.StatusCode] == target
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
 }
 
 func checkResponse(res *http.Response) error {
@@ -34,9 +111,15 @@ func checkResponse(res *http.Response) error {
 	uerr := new(Error)
 	uerr.StatusCode = res.StatusCode
 	uerr.Header = res.Header
+	defer func() {
+		if uerr.RequestID == "" {
+			uerr.RequestID = res.Header.Get(RequestIDHeader)
+		}
+	}()
 	defer res.Body.Close()
 	slurp, err := ioutil.ReadAll(res.Body)
 	if err != nil {
+		uerr.Err = err
 		return uerr
 	}
 	uerr.Body = slurp
@@ -45,12 +128,8 @@ func checkResponse(res *http.Response) error {
 }
 
 func checkStatusCode(err error, status int) bool {
-	if err == nil {
-		return false
-	}
-
-	ae, ok := err.(*Error)
-	return ok && ae.StatusCode == status
+	var ae *Error
+	return errors.As(err, &ae) && ae.StatusCode == status
 }
 
 func IsNotExist(err error) bool {
@@ -65,6 +144,57 @@ func IsTooManyRequests(err error) bool {
 	return checkStatusCode(err, http.StatusTooManyRequests)
 }
 
+// HasCode reports whether err is an Error with the UpYun error code.
+func HasCode(err error, code int) bool {
+	var ae *Error
+	return errors.As(err, &ae) && ae.Code == code
+}
+
+// IsRetryable reports whether the request which failed with err may succeed
+// if sent again: network errors, 408, 429 and 5xx except 501. Certificate
+// errors and malformed requests are not retried. Whether it is safe to
+// resend a non-idempotent request is not considered.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		isCertificateError(err) {
+		return false
+	}
+	var ae *Error
+	if errors.As(err, &ae) {
+		switch {
+		case ae.StatusCode == http.StatusRequestTimeout, ae.StatusCode == http.StatusTooManyRequests:
+			return true
+		case ae.StatusCode >= 500:
+			return ae.StatusCode != http.StatusNotImplemented
+		}
+		return false
+	}
+	// every *url.Error is a net.Error, e.g. "unsupported protocol scheme",
+	// so it is judged by its cause
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		if uerr.Err == io.EOF {
+			// the connection was closed before the response
+			return true
+		}
+		err = uerr.Err
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func isCertificateError(err error) bool {
+	var (
+		verr *tls.CertificateVerificationError
+		uerr x509.UnknownAuthorityError
+		herr x509.HostnameError
+		ierr x509.CertificateInvalidError
+		rerr x509.SystemRootsError
+	)
+	return errors.As(err, &verr) || errors.As(err, &uerr) || errors.As(err, &herr) ||
+		errors.As(err, &ierr) || errors.As(err, &rerr)
+}
+
 func errorOperation(op string, err error) error {
 	if err == nil {
 		return errors.New(op)
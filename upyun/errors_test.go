@@ -0,0 +1,68 @@
+package upyun
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"code":40100005,"msg":"signature error"}`))
+	})
+	err := fake.Delete(&DeleteObjectConfig{Path: "/a"})
+	Equal(t, errors.Is(err, ErrUnauthorized), true)
+	Equal(t, errors.Is(err, ErrNotFound), false)
+	Equal(t, errors.Is(err, &Error{Code: ErrCodeSignature}), true)
+	Equal(t, HasCode(err, ErrCodeSignature), true)
+	Equal(t, IsRetryable(err), false)
+
+	wrapped := fmt.Errorf("sync: %w", err)
+	Equal(t, errors.Is(wrapped, ErrUnauthorized), true)
+	Equal(t, checkStatusCode(wrapped, http.StatusUnauthorized), true)
+
+	err = errorOperation("list", io.ErrUnexpectedEOF)
+	Equal(t, errors.Unwrap(err), io.ErrUnexpectedEOF)
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{&Error{StatusCode: 404}, false},
+		{&Error{StatusCode: 408}, true},
+		{&Error{StatusCode: 429}, true},
+		{&Error{StatusCode: 500}, true},
+		{&Error{StatusCode: 501}, false},
+		{&Error{StatusCode: 503}, true},
+		{errorOperation("get", &net.OpError{Op: "dial", Err: errors.New("refused")}), true},
+		{errorOperation("get", io.ErrUnexpectedEOF), true},
+		{errorOperation("get", context.Canceled), false},
+		{errors.New("no writer"), false},
+		{&url.Error{Op: "Get", URL: "http://a", Err: &net.OpError{Op: "read", Err: errors.New("reset")}}, true},
+		{&url.Error{Op: "Get", URL: "http://a", Err: io.EOF}, true},
+		{&url.Error{Op: "Get", URL: "a", Err: errors.New(`unsupported protocol scheme ""`)}, false},
+		{&url.Error{Op: "parse", URL: ":a", Err: errors.New("missing protocol scheme")}, false},
+		{&url.Error{Op: "Get", URL: "https://a", Err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}}, false},
+		{&url.Error{Op: "Get", URL: "https://a", Err: x509.UnknownAuthorityError{}}, false},
+		{&url.Error{Op: "Get", URL: "https://a", Err: x509.HostnameError{Host: "a"}}, false},
+		{&url.Error{Op: "Get", URL: "https://a", Err: x509.CertificateInvalidError{Reason: x509.Expired}}, false},
+		{&url.Error{Op: "Get", URL: "https://a", Err: x509.SystemRootsError{}}, false},
+	}
+	for _, c := range cases {
+		Equal(t, IsRetryable(c.err), c.retryable)
+	}
+	Equal(t, errors.Is(&Error{StatusCode: 502}, ErrServer), true)
+	Equal(t, shouldRetry("POST", &Error{StatusCode: 503}), false)
+	Equal(t, shouldRetry("POST", &Error{StatusCode: 429}), true)
+}
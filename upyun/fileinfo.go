@@ -16,6 +16,9 @@ type FileInfo struct {
 	Time        time.Time
 
 	Meta map[string]string
+	// NotModified is set if a conditional request is answered by 304, only
+	// Name and the validators are filled then.
+	NotModified bool
 
 	/* image information */
 	ImgType   string
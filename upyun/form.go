@@ -2,6 +2,7 @@ package upyun
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -20,6 +21,10 @@ type FormUploadConfig struct {
 	NotifyUrl      string
 	Apps           []map[string]interface{}
 	Options        map[string]interface{}
+	// Progress, if set, receives the progress of the upload, at most one
+	// ProgressTransferred event per ProgressInterval.
+	Progress         ProgressListener
+	ProgressInterval time.Duration
 }
 
 type FormUploadResp struct {
@@ -54,8 +59,22 @@ func (config *FormUploadConfig) Format() {
 }
 
 func (up *UpYun) FormUpload(config *FormUploadConfig) (*FormUploadResp, error) {
+	return up.FormUploadWithContext(context.Background(), config)
+}
+
+func (up *UpYun) FormUploadWithContext(ctx context.Context, config *FormUploadConfig) (result *FormUploadResp, err error) {
+	// Format fills Options, keep the caller's config untouched
+	c := *config
+	c.Options = make(map[string]interface{}, len(config.Options)+8)
+	for k, v := range config.Options {
+		c.Options[k] = v
+	}
+	config = &c
 	config.Format()
 	config.Options["bucket"] = up.Bucket
+	if config.ExpireAfterSec > 0 {
+		config.Options["expiration"] = up.now().Unix() + config.ExpireAfterSec
+	}
 
 	args, err := json.Marshal(config.Options)
 	if err != nil {
@@ -67,9 +86,12 @@ func (up *UpYun) FormUpload(config *FormUploadConfig) (*FormUploadResp, error) {
 	formValues["policy"] = policy
 	formValues["file"] = config.LocalPath
 
-	if up.deprecated {
+	if up.isDeprecated() {
 		formValues["signature"] = up.MakeFormAuth(policy)
 	} else {
+		if _, err = up.credentials(); err != nil {
+			return nil, err
+		}
 		sign := &UnifiedAuthConfig{
 			Method: "POST",
 			Uri:    "/" + up.Bucket,
@@ -84,9 +106,10 @@ func (up *UpYun) FormUpload(config *FormUploadConfig) (*FormUploadResp, error) {
 		formValues["authorization"] = up.MakeUnifiedAuth(sign)
 	}
 
-	endpoint := up.doGetEndpoint("v0.api.upyun.com")
-	url := fmt.Sprintf("http://%s/%s", endpoint, up.Bucket)
-	resp, err := up.doFormRequest(url, formValues)
+	ctx, progress := startProgress(ctx, config.Progress, config.ProgressInterval, config.SaveKey, -1)
+	defer func() { progress.finish(err) }()
+
+	resp, err := up.doFormRequest(ctx, "/"+up.Bucket, formValues)
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +126,7 @@ func (up *UpYun) FormUpload(config *FormUploadConfig) (*FormUploadResp, error) {
 	return &r, err
 }
 
-func (up *UpYun) doFormRequest(url string, formValues map[string]string) (*http.Response, error) {
+func (up *UpYun) doFormRequest(ctx context.Context, uri string, formValues map[string]string) (*http.Response, error) {
 	formBody := &bytes.Buffer{}
 	formWriter := multipart.NewWriter(formBody)
 	defer formWriter.Close()
@@ -139,8 +162,18 @@ func (up *UpYun) doFormRequest(url string, formValues map[string]string) (*http.
 		"Content-Length": fmt.Sprint(formBody.Len() + int(fInfo.Size()) + bdBuf.Len()),
 	}
 
+	// the multipart envelope is counted by the progress too
+	progressFromContext(ctx).setTotal(int64(formBody.Len()) + fInfo.Size() + int64(bdBuf.Len()))
+
 	body := io.MultiReader(formBody, fd, bdBuf)
-	resp, err := up.doHTTPRequest("POST", url, headers, body)
+	resp, err := up.doHTTPRequest(ctx, &httpReqConfig{
+		op:      "form",
+		method:  "POST",
+		host:    "v0.api.upyun.com",
+		uri:     uri,
+		headers: headers,
+		body:    body,
+	})
 	if err != nil {
 		return nil, errorOperation("form", err)
 	}
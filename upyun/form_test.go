@@ -10,6 +10,7 @@ var (
 )
 
 func TestFormPutFile(t *testing.T) {
+	requireLive(t)
 	resp, err := up.FormUpload(&FormUploadConfig{
 		LocalPath:      LOCAL_FILE,
 		SaveKey:        FORM_FILE,
@@ -21,6 +22,7 @@ func TestFormPutFile(t *testing.T) {
 }
 
 func TestFormPutApps(t *testing.T) {
+	requireLive(t)
 	thumb := map[string]interface{}{
 		"name":           "thumb",
 		"x-gmkerl-thumb": "/fw/120",
@@ -2,21 +2,120 @@ package upyun
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 )
 
-func (up *UpYun) doHTTPRequest(method, url string, headers map[string]string,
-	body io.Reader) (resp *http.Response, err error) {
-	req, err := http.NewRequest(method, url, body)
+type httpReqConfig struct {
+	op     string
+	method string
+	// host is the logical UpYun host, e.g. v0.api.upyun.com, it is mapped
+	// to an endpoint for each attempt.
+	host    string
+	uri     string
+	headers map[string]string
+	// sign sets the Authorization header after the Date header is set,
+	// it is called again before each attempt.
+	sign func(headers map[string]string)
+	body io.Reader
+	// maxTries overrides RetryPolicy.MaxAttempts if > 0
+	maxTries int
+	// endpoint, if set, is the only endpoint the request is sent to
+	endpoint string
+}
+
+func (up *UpYun) doHTTPRequest(ctx context.Context, config *httpReqConfig) (resp *http.Response, err error) {
+	policy := up.retryPolicy()
+	maxTries := policy.MaxAttempts
+	if config.maxTries > 0 {
+		maxTries = config.maxTries
+	}
+
+	var rewind func() error
+	if config.body != nil {
+		rewind = bodyRewinder(config.body)
+	}
+
+	progress := progressFromContext(ctx)
+	group := up.endpointGroup(config.host)
+	failed := make(map[string]bool)
+	resigned := false
+	for try, attempt := 1, 1; ; try, attempt = try+1, attempt+1 {
+		if config.sign != nil {
+			if _, err = up.credentials(); err != nil {
+				return nil, err
+			}
+			config.headers["Date"] = makeRFC1123Date(up.now())
+			config.sign(config.headers)
+		}
+
+		endpoint := group.pick(failed)
+		if config.endpoint != "" {
+			endpoint = config.endpoint
+		}
+		resp, err = up.doHTTPRequestOnce(ctx, config, endpoint)
+		group.report(endpoint, err)
+		if err == nil {
+			progress.commit()
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return resp, err
+		}
+
+		backoff := true
+		switch {
+		case config.sign != nil && !resigned && isClockSkewError(err):
+			// the clock skew has been learnt from the response by now,
+			// sign the request again with the corrected date, only once.
+			resigned, backoff = true, false
+			try--
+		case config.endpoint == "" && isConnectionError(err) && isIdempotent(config.method) &&
+			group.hasHealthy(addKey(failed, endpoint)):
+			// fail over to the next endpoint at once, it is not a retry.
+			backoff = false
+			try--
+		case try >= maxTries || !shouldRetry(config.method, err):
+			return resp, err
+		}
+
+		if config.body != nil {
+			if rewind == nil || rewind() != nil {
+				return nil, err
+			}
+		}
+		progress.retry(-1, attempt, err)
+		if !backoff {
+			continue
+		}
+		if serr := sleepWithContext(ctx, policy.backoff(try, err)); serr != nil {
+			return nil, serr
+		}
+	}
+}
+
+func (up *UpYun) doHTTPRequestOnce(ctx context.Context, config *httpReqConfig, endpoint string) (resp *http.Response, err error) {
+	method, body := config.method, config.body
+	url := fmt.Sprintf("%s://%s%s", up.Scheme, endpoint, config.uri)
+	reqBody := body
+	if _, ok := body.(io.Seeker); ok {
+		// http.Client closes the request body, keep it open so that it can be
+		// rewound and sent again.
+		reqBody = ioutil.NopCloser(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, err
 	}
+	req.Host = requestHost(config.host, endpoint)
 
-	for k, v := range headers {
+	for k, v := range config.headers {
 		if strings.ToLower(k) == "host" {
 			req.Host = v
 		} else {
@@ -25,6 +124,14 @@ func (up *UpYun) doHTTPRequest(method, url string, headers map[string]string,
 	}
 
 	req.Header.Set("User-Agent", up.UserAgent)
+	if id := requestIDFromContext(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	if span := SpanFromContext(ctx); span != nil {
+		if sc := span.SpanContext(); sc.IsValid() {
+			req.Header.Set("traceparent", sc.TraceParent())
+		}
+	}
 	if method == "PUT" || method == "POST" {
 		found := false
 		length := req.Header.Get("Content-Length")
@@ -34,7 +141,7 @@ func (up *UpYun) doHTTPRequest(method, url string, headers map[string]string,
 		} else {
 			switch v := body.(type) {
 			case *os.File:
-				if fInfo, err := v.Stat(); err == nil {
+				if fInfo, err := v.Stat(); err == nil && fInfo.Mode().IsRegular() {
 					req.ContentLength = fInfo.Size()
 					found = true
 				}
@@ -60,20 +167,29 @@ func (up *UpYun) doHTTPRequest(method, url string, headers map[string]string,
 		}
 	}
 
-	//	fmt.Printf("%+v\n", req)
-
-	resp, err = up.httpc.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	err = checkResponse(resp)
-	if err != nil {
-		return nil, err
+	progress := progressFromContext(ctx)
+	progress.begin()
+	if method == "PUT" || method == "POST" {
+		req.Body = progress.reader(req.Body)
 	}
-	return resp, nil
+
+	resp, err = up.handler()(config.op, req)
+	recordResponseMeta(ctx, resp, err)
+	return resp, err
 }
 
+func addKey(m map[string]bool, key string) map[string]bool {
+	m[key] = true
+	return m
+}
+
+// doGetEndpoint returns the endpoint to which requests for the logical
+// host are sent currently.
 func (up *UpYun) doGetEndpoint(host string) string {
+	return up.endpointGroup(host).pick(nil)
+}
+
+func (up *UpYun) legacyEndpoint(host string) string {
 	s := up.Hosts[host]
 	if s != "" {
 		return s
@@ -1,9 +1,14 @@
 package upyun
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 type UpYunPutReader interface {
@@ -26,8 +31,10 @@ func (f *fragmentFile) Seek(offset int64, whence int) (ret int64, err error) {
 		f.cursor = offset
 		ret, err = f.realFile.Seek(f.offset+f.cursor, 0)
 		return ret - f.offset, err
+	case 1:
+		return f.Seek(f.cursor+offset, 0)
 	default:
-		return 0, fmt.Errorf("whence must be 0")
+		return 0, fmt.Errorf("whence must be 0 or 1")
 	}
 }
 
@@ -76,3 +83,89 @@ func newFragmentFile(file *os.File, offset, limit int64) (*fragmentFile, error)
 	}
 	return f, nil
 }
+
+// atomicFile is written through a temporary file in the directory of name,
+// which replaces name on commit, so name is never left partially written.
+type atomicFile struct {
+	*os.File
+	name string
+	done bool
+}
+
+// The file keeps the mode of an existing name, a new one gets 0666 less the
+// umask like os.Create.
+func createAtomicFile(name string) (*atomicFile, error) {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := createTempFile(dir, "."+base+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	if fInfo, serr := os.Stat(name); serr == nil {
+		if err = f.Chmod(fInfo.Mode().Perm()); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+	}
+	return &atomicFile{File: f, name: name}, nil
+}
+
+// createTempFile is ioutil.TempFile, except that the mode is 0666 before
+// the umask rather than 0600.
+func createTempFile(dir, prefix string) (*os.File, error) {
+	b := make([]byte, 8)
+	for try := 0; ; try++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		name := filepath.Join(dir, prefix+hex.EncodeToString(b))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && try < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// commit syncs the temporary file and renames it to name, its mtime is set
+// to modTime unless it is zero.
+func (f *atomicFile) commit(modTime time.Time) error {
+	err := f.Sync()
+	if err == nil {
+		err = f.File.Close()
+	}
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(f.Name(), modTime, modTime)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), f.name)
+	}
+	if err != nil {
+		f.abort()
+		return err
+	}
+	f.done = true
+	return nil
+}
+
+// abort removes the temporary file, unless it has been committed.
+func (f *atomicFile) abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+// verifyMD5 compares the md5 hex digest sum with expected, which comes
+// from Content-MD5 or ETag. It is skipped if expected is not a md5 digest.
+func verifyMD5(expected, sum string) error {
+	if !md5HexRegexp.MatchString(expected) || strings.EqualFold(expected, sum) {
+		return nil
+	}
+	return fmt.Errorf("%w: expected %s, got %s", ErrMD5Mismatch, expected, sum)
+}
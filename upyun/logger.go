@@ -0,0 +1,189 @@
+package upyun
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger is a leveled, structured logger. keyvals are alternating keys and
+// values, so a *slog.Logger from log/slog can be used as a Logger directly.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// LogLevel has the same values as slog.Level.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = -4
+	LogLevelInfo  LogLevel = 0
+	LogLevelWarn  LogLevel = 4
+	LogLevelError LogLevel = 8
+)
+
+func (l LogLevel) String() string {
+	switch {
+	case l < LogLevelInfo:
+		return "DEBUG"
+	case l < LogLevelWarn:
+		return "INFO"
+	case l < LogLevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+const redacted = "REDACTED"
+
+// sensitive header, query and form keys, compared in lower case
+var sensitiveKeys = map[string]bool{
+	"authorization": true,
+	"policy":        true,
+	"signature":     true,
+	"password":      true,
+	"_upt":          true,
+}
+
+// NewLogger returns a Logger writing one "key=value" line per record with
+// a level >= level to w.
+func NewLogger(w io.Writer, level LogLevel) Logger {
+	return &textLogger{w: w, level: level}
+}
+
+type textLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level LogLevel
+}
+
+func (l *textLogger) Debug(msg string, keyvals ...interface{}) { l.log(LogLevelDebug, msg, keyvals) }
+func (l *textLogger) Info(msg string, keyvals ...interface{})  { l.log(LogLevelInfo, msg, keyvals) }
+func (l *textLogger) Warn(msg string, keyvals ...interface{})  { l.log(LogLevelWarn, msg, keyvals) }
+func (l *textLogger) Error(msg string, keyvals ...interface{}) { l.log(LogLevelError, msg, keyvals) }
+
+func (l *textLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "time=%s level=%s msg=%q", time.Now().Format(time.RFC3339), level, msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		var v interface{} = "!MISSING"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		if sensitiveKeys[strings.ToLower(key)] {
+			v = redacted
+		}
+		fmt.Fprintf(&b, " %s=%q", key, fmt.Sprint(v))
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// SetLogger sets the logger which records every request sent by up,
+// credentials are never logged.
+func (up *UpYun) SetLogger(logger Logger) {
+	up.settingsMu.Lock()
+	defer up.settingsMu.Unlock()
+	up.logger = logger
+}
+
+func (up *UpYun) log() Logger {
+	up.settingsMu.RLock()
+	defer up.settingsMu.RUnlock()
+	if up.logger != nil {
+		return up.logger
+	}
+	return nopLogger{}
+}
+
+// logRequest is the innermost middleware, it logs every attempt of a request.
+func (up *UpYun) logRequest(next Handler) Handler {
+	return func(op string, req *http.Request) (*http.Response, error) {
+		logger := up.log()
+		logger.Debug("upyun request",
+			"op", op,
+			"method", req.Method,
+			"uri", redactURI(req.URL),
+			"headers", redactHeader(req.Header),
+		)
+
+		start := time.Now()
+		resp, err := next(op, req)
+		keyvals := []interface{}{
+			"op", op,
+			"method", req.Method,
+			"uri", redactURI(req.URL),
+			"latency", time.Since(start),
+		}
+
+		var ae *Error
+		switch {
+		case err == nil:
+			logger.Debug("upyun response", append(keyvals,
+				"status", resp.StatusCode,
+				"request_id", resp.Header.Get(RequestIDHeader),
+			)...)
+		case errors.As(err, &ae):
+			logger.Warn("upyun response", append(keyvals,
+				"status", ae.StatusCode,
+				"code", ae.Code,
+				"request_id", ae.RequestID,
+				"error", ae.Message,
+			)...)
+		default:
+			logger.Error("upyun request failed", append(keyvals, "error", err)...)
+		}
+		return resp, err
+	}
+}
+
+func redactURI(u *url.URL) string {
+	uri := u.EscapedPath()
+	if u.RawQuery == "" {
+		return uri
+	}
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return uri + "?" + redacted
+	}
+	for k := range q {
+		if sensitiveKeys[strings.ToLower(k)] {
+			q.Set(k, redacted)
+		}
+	}
+	return uri + "?" + q.Encode()
+}
+
+func redactHeader(header http.Header) http.Header {
+	h := make(http.Header, len(header))
+	for k, v := range header {
+		if sensitiveKeys[strings.ToLower(k)] {
+			v = []string{redacted}
+		}
+		h[k] = v
+	}
+	return h
+}
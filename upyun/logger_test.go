@@ -0,0 +1,62 @@
+package upyun
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRedaction(t *testing.T) {
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "rid-1")
+		w.Write([]byte("1"))
+	})
+	var buf bytes.Buffer
+	fake.SetLogger(NewLogger(&buf, LogLevelDebug))
+
+	_, err := fake.Usage()
+	Nil(t, err)
+
+	out := buf.String()
+	Equal(t, strings.Contains(out, `op="usage"`), true)
+	Equal(t, strings.Contains(out, `request_id="rid-1"`), true)
+	Equal(t, strings.Contains(out, "UpYun operator:"), false)
+	Equal(t, strings.Contains(out, redacted), true)
+
+	_, err = fake.GetProgress([]string{"id"})
+	NotNil(t, err)
+	Equal(t, strings.Contains(buf.String(), `op="process"`), true)
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LogLevelWarn)
+	logger.Info("hidden")
+	logger.Warn("shown", "authorization", "secret")
+	Equal(t, strings.Contains(buf.String(), "hidden"), false)
+	Equal(t, strings.Contains(buf.String(), `msg="shown" authorization="REDACTED"`), true)
+}
+
+func TestLoggerSyncProcessBody(t *testing.T) {
+	body := strings.Repeat("x", 4096)
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	})
+	var buf bytes.Buffer
+	fake.SetLogger(NewLogger(&buf, LogLevelInfo))
+
+	task := SyncCommonTask{Kwargs: map[string]interface{}{"a": "b"}, TaskUri: "/task"}
+	_, err := fake.CommitSyncTasks(task)
+	NotNil(t, err)
+	Equal(t, strings.Contains(buf.String(), "unmarshal"), false)
+
+	buf.Reset()
+	fake.SetLogger(NewLogger(&buf, LogLevelDebug))
+	_, err = fake.CommitSyncTasks(task)
+	NotNil(t, err)
+	out := buf.String()
+	Equal(t, strings.Contains(out, "level=DEBUG msg=\"can't unmarshal the data\""), true)
+	Equal(t, strings.Contains(out, `body_size="4096"`), true)
+	Equal(t, strings.Contains(out, strings.Repeat("x", maxLoggedBody+1)), false)
+}
@@ -0,0 +1,111 @@
+package upyun
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestIDHeader carries the request id of both requests and responses.
+const RequestIDHeader = "X-Request-Id"
+
+// ResponseMeta is the metadata of the last response received by an
+// operation, successful or not.
+type ResponseMeta struct {
+	// RequestID identifies the request to UpYun support
+	RequestID  string
+	StatusCode int
+	// Date is the server time of the response
+	Date time.Time
+	// Header holds the x-upyun-* headers of the response
+	Header http.Header
+}
+
+type responseMetaKey struct{}
+type requestIDKey struct{}
+
+// responseMetaHolder serializes the writes of the concurrent requests of an
+// operation, e.g. the chunks of Download.
+type responseMetaHolder struct {
+	mu   sync.Mutex
+	meta *ResponseMeta
+}
+
+// WithResponseMeta returns a context which makes the operations called with
+// it fill meta, e.g.
+//
+//	var meta upyun.ResponseMeta
+//	err := up.PutWithContext(upyun.WithResponseMeta(ctx, &meta), config)
+//	log.Println(meta.RequestID, err)
+//
+// meta must not be shared by concurrent operations. An operation which
+// sends concurrent requests, e.g. Download, fills it with the response
+// received last.
+func WithResponseMeta(ctx context.Context, meta *ResponseMeta) context.Context {
+	return context.WithValue(ctx, responseMetaKey{}, &responseMetaHolder{meta: meta})
+}
+
+// withoutResponseMeta returns ctx for requests which may outlive the
+// operation, they must not fill its ResponseMeta.
+func withoutResponseMeta(ctx context.Context) context.Context {
+	if ctx.Value(responseMetaKey{}) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, responseMetaKey{}, (*responseMetaHolder)(nil))
+}
+
+// WithRequestID returns a context which makes the requests sent with it
+// carry id in the X-Request-Id header.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// recordResponseMeta fills the ResponseMeta of ctx, if any, from the
+// response or the error of a request.
+func recordResponseMeta(ctx context.Context, resp *http.Response, err error) {
+	holder, _ := ctx.Value(responseMetaKey{}).(*responseMetaHolder)
+	if holder == nil {
+		return
+	}
+
+	var status int
+	var header http.Header
+	var ae *Error
+	switch {
+	case resp != nil:
+		status, header = resp.StatusCode, resp.Header
+	case errors.As(err, &ae):
+		status, header = ae.StatusCode, ae.Header
+	default:
+		return
+	}
+
+	meta := ResponseMeta{
+		RequestID:  header.Get(RequestIDHeader),
+		StatusCode: status,
+		Header:     make(http.Header),
+	}
+	if ae != nil && meta.RequestID == "" {
+		meta.RequestID = ae.RequestID
+	}
+	if t, err := http.ParseTime(header.Get("Date")); err == nil {
+		meta.Date = t
+	}
+	for k, v := range header {
+		if strings.HasPrefix(strings.ToLower(k), "x-upyun-") {
+			meta.Header[k] = append([]string(nil), v...)
+		}
+	}
+
+	holder.mu.Lock()
+	*holder.meta = meta
+	holder.mu.Unlock()
+}
@@ -0,0 +1,68 @@
+package upyun
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestResponseMeta(t *testing.T) {
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" {
+			id = "server-id"
+		}
+		w.Header().Set("X-Request-Id", id)
+		w.Header().Set("X-Upyun-Content-Length", "5")
+		w.Header().Set("Date", "Tue, 13 Oct 2026 08:00:00 GMT")
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	var meta ResponseMeta
+	ctx := WithResponseMeta(context.Background(), &meta)
+	err := fake.PutWithContext(ctx, &PutObjectConfig{Path: "/a", Reader: strings.NewReader("hello")})
+	Nil(t, err)
+	Equal(t, meta.RequestID, "server-id")
+	Equal(t, meta.StatusCode, http.StatusOK)
+	Equal(t, meta.Date.Day(), 13)
+	Equal(t, meta.Header.Get("X-Upyun-Content-Length"), "5")
+	Equal(t, meta.Header.Get("Date"), "")
+
+	ctx = WithRequestID(ctx, "my-id")
+	Nil(t, fake.MkdirWithContext(ctx, "/dir"))
+	Equal(t, meta.RequestID, "my-id")
+
+	err = fake.DeleteWithContext(ctx, &DeleteObjectConfig{Path: "/a"})
+	Equal(t, IsNotExist(err), true)
+	Equal(t, meta.StatusCode, http.StatusNotFound)
+	Equal(t, meta.RequestID, "my-id")
+	Equal(t, err.(*Error).RequestID, "my-id")
+}
+
+func TestResponseMetaConcurrentRequests(t *testing.T) {
+	bucket := newFakeBucket()
+	bucket.put("/media", bytes.Repeat([]byte("m"), 1<<20))
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", r.Header.Get("Range"))
+		w.Header().Set("X-Upyun-Range", r.Header.Get("Range"))
+		bucket.ServeHTTP(w, r)
+	})
+
+	var meta ResponseMeta
+	ctx := WithResponseMeta(context.Background(), &meta)
+	var mem memWriterAt
+	_, err := fake.DownloadWithContext(ctx, &DownloadConfig{
+		Path:        "/media",
+		WriterAt:    &mem,
+		ChunkSize:   32 * 1024,
+		Concurrency: 8,
+	})
+	Nil(t, err)
+	Equal(t, meta.StatusCode, http.StatusPartialContent)
+	Equal(t, strings.HasPrefix(meta.RequestID, "bytes="), true)
+	Equal(t, meta.Header.Get("X-Upyun-Range"), meta.RequestID)
+}
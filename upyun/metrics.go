@@ -0,0 +1,327 @@
+package upyun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ErrorClassClient    = "client"
+	ErrorClassServer    = "server"
+	ErrorClassThrottled = "throttled"
+	ErrorClassNetwork   = "network"
+	ErrorClassCanceled  = "canceled"
+)
+
+// RequestMetrics describes one http request (one attempt) sent by UpYun.
+type RequestMetrics struct {
+	// Operation is the operation name without object path,
+	// e.g. "put", "get", "list", "upload multipart", "process", "purge".
+	Operation string
+	Method    string
+	// StatusCode is 0 if there is no response
+	StatusCode int
+	// Code is the UpYun error code, 0 on success
+	Code int
+	// ErrorClass is one of ErrorClass*, "" on success
+	ErrorClass    string
+	Duration      time.Duration
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// MetricsCollector receives the metrics of every request. The metrics of a
+// successful request are delivered when its response body is closed, so
+// Duration and BytesReceived cover the whole transfer.
+type MetricsCollector interface {
+	ObserveRequest(m *RequestMetrics)
+}
+
+// SetMetricsCollector sets the collector which receives the metrics of
+// every request sent by up.
+func (up *UpYun) SetMetricsCollector(c MetricsCollector) {
+	up.settingsMu.Lock()
+	defer up.settingsMu.Unlock()
+	up.metrics = c
+}
+
+func (up *UpYun) metricsCollector() MetricsCollector {
+	up.settingsMu.RLock()
+	defer up.settingsMu.RUnlock()
+	return up.metrics
+}
+
+// opName strips the object path from op, "put /a.txt" => "put"
+func opName(op string) string {
+	if i := strings.Index(op, " /"); i >= 0 {
+		return op[:i]
+	}
+	return op
+}
+
+func errorClass(err error) string {
+	var ae *Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &ae):
+		switch {
+		case ae.StatusCode == http.StatusTooManyRequests:
+			return ErrorClassThrottled
+		case ae.StatusCode >= 500:
+			return ErrorClassServer
+		default:
+			return ErrorClassClient
+		}
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassCanceled
+	default:
+		return ErrorClassNetwork
+	}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+// observedBody reports the metrics when the response body is closed.
+type observedBody struct {
+	countingReader
+	once    sync.Once
+	observe func(received int64)
+}
+
+func (b *observedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.observe(atomic.LoadInt64(&b.n)) })
+	return err
+}
+
+// observeRequest is an internal middleware which reports RequestMetrics.
+func (up *UpYun) observeRequest(next Handler) Handler {
+	return func(op string, req *http.Request) (*http.Response, error) {
+		c := up.metricsCollector()
+		if c == nil {
+			return next(op, req)
+		}
+
+		var sent *countingReader
+		if req.Body != nil {
+			sent = &countingReader{ReadCloser: req.Body}
+			req.Body = sent
+		}
+		m := &RequestMetrics{
+			Operation: opName(op),
+			Method:    req.Method,
+		}
+
+		start := time.Now()
+		resp, err := next(op, req)
+		if sent != nil {
+			m.BytesSent = atomic.LoadInt64(&sent.n)
+		}
+		if err != nil {
+			var ae *Error
+			if errors.As(err, &ae) {
+				m.StatusCode, m.Code = ae.StatusCode, ae.Code
+				m.BytesReceived = int64(len(ae.Body))
+			}
+			m.ErrorClass = errorClass(err)
+			m.Duration = time.Since(start)
+			c.ObserveRequest(m)
+			return nil, err
+		}
+
+		m.StatusCode = resp.StatusCode
+		resp.Body = &observedBody{
+			countingReader: countingReader{ReadCloser: resp.Body},
+			observe: func(received int64) {
+				m.BytesReceived = received
+				m.Duration = time.Since(start)
+				c.ObserveRequest(m)
+			},
+		}
+		return resp, nil
+	}
+}
+
+// DefaultDurationBuckets are the upper bounds (in seconds) of the request
+// duration histogram.
+var DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// PrometheusCollector is a MetricsCollector which aggregates metrics in
+// memory and serves them in the Prometheus text exposition format.
+type PrometheusCollector struct {
+	mu        sync.Mutex
+	buckets   []float64
+	requests  map[[3]string]uint64 // operation, method, status
+	errors    map[[3]string]uint64 // operation, class, code
+	durations map[string]*histogram
+	sent      map[string]int64
+	received  map[string]int64
+}
+
+type histogram struct {
+	counts []uint64 // counts[i] is the number of samples <= buckets[i]
+	count  uint64
+	sum    float64
+}
+
+// NewPrometheusCollector returns a collector using DefaultDurationBuckets
+// if no buckets are given.
+func NewPrometheusCollector(buckets ...float64) *PrometheusCollector {
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &PrometheusCollector{
+		buckets:   buckets,
+		requests:  make(map[[3]string]uint64),
+		errors:    make(map[[3]string]uint64),
+		durations: make(map[string]*histogram),
+		sent:      make(map[string]int64),
+		received:  make(map[string]int64),
+	}
+}
+
+func (c *PrometheusCollector) ObserveRequest(m *RequestMetrics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests[[3]string{m.Operation, m.Method, strconv.Itoa(m.StatusCode)}]++
+	if m.ErrorClass != "" {
+		c.errors[[3]string{m.Operation, m.ErrorClass, strconv.Itoa(m.Code)}]++
+	}
+
+	h := c.durations[m.Operation]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(c.buckets))}
+		c.durations[m.Operation] = h
+	}
+	sec := m.Duration.Seconds()
+	for i, le := range c.buckets {
+		if sec <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += sec
+
+	c.sent[m.Operation] += m.BytesSent
+	c.received[m.Operation] += m.BytesReceived
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (c *PrometheusCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format to w.
+func (c *PrometheusCollector) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# HELP upyun_requests_total Total number of requests sent to UpYun.\n")
+	b.WriteString("# TYPE upyun_requests_total counter\n")
+	for _, k := range sortedKeys3(c.requests) {
+		fmt.Fprintf(&b, "upyun_requests_total{operation=%s,method=%s,status=%s} %d\n",
+			quoteLabel(k[0]), quoteLabel(k[1]), quoteLabel(k[2]), c.requests[k])
+	}
+
+	b.WriteString("# HELP upyun_request_errors_total Total number of failed requests.\n")
+	b.WriteString("# TYPE upyun_request_errors_total counter\n")
+	for _, k := range sortedKeys3(c.errors) {
+		fmt.Fprintf(&b, "upyun_request_errors_total{operation=%s,class=%s,code=%s} %d\n",
+			quoteLabel(k[0]), quoteLabel(k[1]), quoteLabel(k[2]), c.errors[k])
+	}
+
+	b.WriteString("# HELP upyun_request_duration_seconds Request latency, including the transfer of the body.\n")
+	b.WriteString("# TYPE upyun_request_duration_seconds histogram\n")
+	for _, op := range sortedKeys(c.durations) {
+		h, label := c.durations[op], quoteLabel(op)
+		for i, le := range c.buckets {
+			fmt.Fprintf(&b, "upyun_request_duration_seconds_bucket{operation=%s,le=%q} %d\n",
+				label, formatFloat(le), h.counts[i])
+		}
+		fmt.Fprintf(&b, "upyun_request_duration_seconds_bucket{operation=%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(&b, "upyun_request_duration_seconds_sum{operation=%s} %s\n", label, formatFloat(h.sum))
+		fmt.Fprintf(&b, "upyun_request_duration_seconds_count{operation=%s} %d\n", label, h.count)
+	}
+
+	b.WriteString("# HELP upyun_sent_bytes_total Total number of request body bytes sent.\n")
+	b.WriteString("# TYPE upyun_sent_bytes_total counter\n")
+	for _, op := range sortedKeys(c.sent) {
+		fmt.Fprintf(&b, "upyun_sent_bytes_total{operation=%s} %d\n", quoteLabel(op), c.sent[op])
+	}
+
+	b.WriteString("# HELP upyun_received_bytes_total Total number of response body bytes received.\n")
+	b.WriteString("# TYPE upyun_received_bytes_total counter\n")
+	for _, op := range sortedKeys(c.received) {
+		fmt.Fprintf(&b, "upyun_received_bytes_total{operation=%s} %d\n", quoteLabel(op), c.received[op])
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func quoteLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys3(m map[[3]string]uint64) [][3]string {
+	keys := make([][3]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		for n := 0; n < 3; n++ {
+			if keys[i][n] != keys[j][n] {
+				return keys[i][n] < keys[j][n]
+			}
+		}
+		return false
+	})
+	return keys
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]*histogram:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]int64:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,148 @@
+package upyun
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrometheusCollector(t *testing.T) {
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		switch r.Method {
+		case "PUT":
+		case "GET":
+			w.Write([]byte(BUF_CONTENT))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":40400001,"msg":"file or directory not found"}`))
+		}
+	})
+	c := NewPrometheusCollector()
+	fake.SetMetricsCollector(c)
+
+	err := fake.Put(&PutObjectConfig{
+		Path:   "/a.txt",
+		Reader: strings.NewReader(BUF_CONTENT),
+	})
+	Nil(t, err)
+	_, err = fake.Get(&GetObjectConfig{
+		Path:   "/a.txt",
+		Writer: ioutil.Discard,
+	})
+	Nil(t, err)
+	err = fake.Delete(&DeleteObjectConfig{Path: "/a.txt"})
+	Equal(t, IsNotExist(err), true)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	for _, line := range []string{
+		`upyun_requests_total{operation="put",method="PUT",status="200"} 1`,
+		`upyun_requests_total{operation="get",method="GET",status="200"} 1`,
+		`upyun_request_errors_total{operation="delete",class="client",code="40400001"} 1`,
+		`upyun_request_duration_seconds_count{operation="get"} 1`,
+		`upyun_sent_bytes_total{operation="put"} 12`,
+		`upyun_received_bytes_total{operation="get"} 12`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("%q not found in:\n%s", line, out)
+		}
+	}
+}
+
+type opRecorder struct {
+	mu  sync.Mutex
+	ops map[string]int
+}
+
+func (r *opRecorder) ObserveRequest(m *RequestMetrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops[m.Operation]++
+}
+
+func (r *opRecorder) observed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ops []string
+	for op := range r.ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+func TestMetricsEveryOperation(t *testing.T) {
+	bucket := newFakeBucket()
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.RawQuery == "usage":
+			w.Write([]byte("123"))
+		case r.URL.Path == "/purge/", strings.HasSuffix(r.URL.Path, "/liveaudit/cancel"):
+			w.Write([]byte("{}"))
+		case r.URL.Path == "/pretreatment/":
+			w.Write([]byte(`["task"]`))
+		case r.URL.Path == "/status/":
+			w.Write([]byte(`{"tasks": {"task": 100}}`))
+		case r.Method == "PATCH":
+		case r.Method == "GET" && r.Header.Get("X-Upyun-List-Type") == "multi":
+			w.Write([]byte("{}"))
+		case r.Method == "GET" && r.Header.Get("X-Upyun-Multi-Uuid") != "":
+			w.Write([]byte("{}"))
+		default:
+			bucket.ServeHTTP(w, r)
+		}
+	})
+	fake.Hosts["purge.upyun.com"] = fake.Hosts["v0.api.upyun.com"]
+	rec := &opRecorder{ops: map[string]int{}}
+	fake.SetMetricsCollector(rec)
+
+	_, err := fake.Usage()
+	Nil(t, err)
+	Nil(t, fake.Mkdir("/dir"))
+	Nil(t, fake.Put(&PutObjectConfig{Path: "/a", Reader: strings.NewReader("a")}))
+	_, err = fake.Get(&GetObjectConfig{Path: "/a", Writer: ioutil.Discard})
+	Nil(t, err)
+	_, err = fake.GetInfo("/a")
+	Nil(t, err)
+	Nil(t, fake.List(&GetObjectsConfig{Path: "/", ObjectsChan: make(chan *FileInfo, 10)}))
+	Nil(t, fake.Move(&MoveObjectConfig{SrcPath: "/a", DestPath: "/b"}))
+	Nil(t, fake.Copy(&CopyObjectConfig{SrcPath: "/b", DestPath: "/c"}))
+	Nil(t, fake.ModifyMetadata(&ModifyMetadataConfig{Path: "/c"}))
+	Nil(t, fake.Delete(&DeleteObjectConfig{Path: "/c"}))
+
+	initResult, err := fake.InitMultipartUpload(&InitMultipartUploadConfig{Path: "/m", PartSize: DefaultPartSize})
+	Nil(t, err)
+	Nil(t, fake.UploadPart(initResult, &UploadPartConfig{PartID: 0, PartSize: 1, Reader: strings.NewReader("m")}))
+	_, err = fake.ListMultipartUploads(&ListMultipartConfig{})
+	Nil(t, err)
+	_, err = fake.ListMultipartParts(initResult, &ListMultipartPartsConfig{})
+	Nil(t, err)
+	Nil(t, fake.CompleteMultipartUpload(initResult, &CompleteMultipartUploadConfig{}))
+
+	fname := filepath.Join(t.TempDir(), "form")
+	Nil(t, ioutil.WriteFile(fname, []byte("form"), 0644))
+	_, err = fake.FormUpload(&FormUploadConfig{LocalPath: fname, SaveKey: "/form"})
+	Nil(t, err)
+	_, err = fake.CommitTasks(&CommitTasksConfig{})
+	Nil(t, err)
+	_, err = fake.GetProgress([]string{"task"})
+	Nil(t, err)
+	_, err = fake.CommitSyncTasks(LiveauditCancelTask{TaskId: "task"})
+	Nil(t, err)
+	_, err = fake.Purge([]string{"http://example.com/a"})
+	Nil(t, err)
+
+	Equal(t, strings.Join(rec.observed(), ","), strings.Join([]string{
+		"complete multipart", "copy source", "delete", "form", "get", "get info",
+		"init multipart", "list", "list multipart", "list multipart parts",
+		"mkdir", "modify metadata", "move source", "process", "purge",
+		"put", "sync process", "upload multipart", "usage",
+	}, ","))
+}
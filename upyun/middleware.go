@@ -0,0 +1,51 @@
+package upyun
+
+import (
+	"net/http"
+)
+
+// Handler sends req on behalf of the operation op, e.g. "put /a.txt",
+// "upload multipart" or "purge" (the same name found in Error.Operation).
+// A non-2xx response is returned as an *Error.
+type Handler func(op string, req *http.Request) (*http.Response, error)
+
+// Middleware wraps a Handler. It may modify the request before calling next,
+// inspect or replace the response and error returned by next, or
+// short-circuit the request by not calling next at all.
+type Middleware func(next Handler) Handler
+
+// Use appends middlewares to the chain around every http request sent by up,
+// including each retried attempt. The first middleware is the outermost.
+func (up *UpYun) Use(mw ...Middleware) {
+	up.mwMu.Lock()
+	defer up.mwMu.Unlock()
+	// copy on write, requests in flight keep the old chain
+	chain := make([]Middleware, 0, len(up.middlewares)+len(mw))
+	chain = append(chain, up.middlewares...)
+	up.middlewares = append(chain, mw...)
+}
+
+func (up *UpYun) handler() Handler {
+	up.mwMu.RLock()
+	chain := up.middlewares
+	up.mwMu.RUnlock()
+
+	h := up.limitRequest(up.logRequest(up.observeRequest(up.roundTrip)))
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i](h)
+	}
+	return h
+}
+
+func (up *UpYun) roundTrip(op string, req *http.Request) (*http.Response, error) {
+	resp, err := up.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	up.learnClockSkew(resp.Header)
+	err = checkResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
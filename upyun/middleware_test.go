@@ -0,0 +1,57 @@
+package upyun
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, r.Header.Get("X-Stamp"), "outer,inner")
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	var ops []string
+	fake.Use(func(next Handler) Handler {
+		return func(op string, req *http.Request) (*http.Response, error) {
+			req.Header.Set("X-Stamp", "outer")
+			ops = append(ops, op)
+			resp, err := next(op, req)
+			Equal(t, IsNotExist(err), true)
+			return resp, err
+		}
+	}, func(next Handler) Handler {
+		return func(op string, req *http.Request) (*http.Response, error) {
+			req.Header.Set("X-Stamp", req.Header.Get("X-Stamp")+",inner")
+			return next(op, req)
+		}
+	})
+
+	_, err := fake.GetInfo("/a")
+	Equal(t, IsNotExist(err), true)
+	err = fake.Mkdir("/a")
+	Equal(t, IsNotExist(err), true)
+	Equal(t, ops, []string{"get info", "mkdir /a"})
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("request should not be sent")
+	})
+	fake.Use(func(next Handler) Handler {
+		return func(op string, req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader("42")),
+				Request:    req,
+			}, nil
+		}
+	})
+
+	n, err := fake.Usage()
+	Nil(t, err)
+	Equal(t, n, int64(42))
+}
@@ -0,0 +1,208 @@
+package upyun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// DefaultObjectReadAhead is the least number of bytes ObjectReader.Read
+// fetches by a ranged GET.
+const DefaultObjectReadAhead = 256 * 1024
+
+// ObjectReader gives random access to an object by ranged GETs. It
+// implements io.ReadSeekCloser and io.ReaderAt, ReadAt can be called
+// concurrently with the other methods.
+type ObjectReader struct {
+	up   *UpYun
+	ctx  context.Context
+	path string
+	info *FileInfo
+
+	mu     sync.Mutex
+	off    int64
+	buf    []byte
+	bufOff int64
+	closed bool
+}
+
+var (
+	_ io.ReadSeeker = (*ObjectReader)(nil)
+	_ io.ReaderAt   = (*ObjectReader)(nil)
+	_ io.Closer     = (*ObjectReader)(nil)
+)
+
+func (up *UpYun) OpenObject(path string) (*ObjectReader, error) {
+	return up.OpenObjectWithContext(context.Background(), path)
+}
+
+// OpenObjectWithContext gets the info of the object by GetInfo, nothing is
+// downloaded until it is read. ctx is used by every read.
+func (up *UpYun) OpenObjectWithContext(ctx context.Context, path string) (*ObjectReader, error) {
+	fInfo, err := up.GetInfoWithContext(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if fInfo.IsDir {
+		return nil, fmt.Errorf("open %s: is a directory", path)
+	}
+	return &ObjectReader{up: up, ctx: ctx, path: path, info: fInfo}, nil
+}
+
+// Size returns the size of the object when it was opened.
+func (r *ObjectReader) Size() int64 {
+	return r.info.Size
+}
+
+// FileInfo returns the info of the object got by OpenObject.
+func (r *ObjectReader) FileInfo() *FileInfo {
+	return r.info
+}
+
+func (r *ObjectReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	size := r.info.Size
+	if r.off >= size {
+		return 0, io.EOF
+	}
+
+	if r.off < r.bufOff || r.off >= r.bufOff+int64(len(r.buf)) {
+		r.buf = r.buf[:0]
+		if len(p) >= DefaultObjectReadAhead {
+			// large enough, skip the buffer
+			n, err := r.fetch(p[:min64(int64(len(p)), size-r.off)], r.off)
+			r.off += int64(n)
+			return n, err
+		}
+		n := min64(DefaultObjectReadAhead, size-r.off)
+		if int64(cap(r.buf)) < n {
+			r.buf = make([]byte, n)
+		}
+		m, err := r.fetch(r.buf[:n], r.off)
+		r.buf, r.bufOff = r.buf[:m], r.off
+		if m == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf[r.off-r.bufOff:])
+	r.off += int64(n)
+	return n, nil
+}
+
+// ReadAt reads len(p) bytes at off by one ranged GET, unless they are in the
+// read-ahead buffer of Read. It does not change the offset of Read.
+func (r *ObjectReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("upyun: negative offset")
+	}
+	size := r.info.Size
+	if off >= size {
+		return 0, io.EOF
+	}
+	n := min64(int64(len(p)), size-off)
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return 0, os.ErrClosed
+	}
+	if off >= r.bufOff && off+n <= r.bufOff+int64(len(r.buf)) {
+		copy(p, r.buf[off-r.bufOff:])
+		r.mu.Unlock()
+	} else {
+		r.mu.Unlock()
+		m, err := r.fetch(p[:n], off)
+		if err != nil {
+			return m, err
+		}
+	}
+	if n < int64(len(p)) {
+		return int(n), io.EOF
+	}
+	return int(n), nil
+}
+
+func (r *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.info.Size
+	default:
+		return 0, errors.New("upyun: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("upyun: negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (r *ObjectReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	}
+	r.closed, r.buf = true, nil
+	return nil
+}
+
+// fetch fills p with the bytes of the object at off.
+func (r *ObjectReader) fetch(p []byte, off int64) (int, error) {
+	w := &sliceWriter{buf: p}
+	fInfo, err := r.up.GetWithContext(r.ctx, &GetObjectConfig{
+		Path:   r.path,
+		Writer: w,
+		Offset: off,
+		Length: int64(len(p)),
+	})
+	if err != nil {
+		return w.n, err
+	}
+	if fInfo.Size >= 0 && fInfo.Size != r.info.Size {
+		return 0, fmt.Errorf("read %s: object size changed from %d to %d", r.path, r.info.Size, fInfo.Size)
+	}
+	if w.n < len(p) {
+		return w.n, io.ErrUnexpectedEOF
+	}
+	return w.n, nil
+}
+
+// sliceWriter writes to a fixed size buffer
+type sliceWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	n := copy(w.buf[w.n:], p)
+	w.n += n
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
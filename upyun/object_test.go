@@ -0,0 +1,123 @@
+package upyun
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestObjectReader(t *testing.T) {
+	bucket := newFakeBucket()
+	content := make([]byte, DefaultObjectReadAhead*3+123)
+	rand.Read(content)
+	bucket.put("/media", content)
+	bucket.put("/dir/a", nil)
+
+	var gets int32
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+		}
+		bucket.ServeHTTP(w, r)
+	})
+
+	r, err := fake.OpenObject("/media")
+	Nil(t, err)
+	Equal(t, r.Size(), int64(len(content)))
+	Equal(t, r.FileInfo().Size, int64(len(content)))
+	Equal(t, atomic.LoadInt32(&gets), int32(0))
+	var _ io.ReadSeekCloser = r
+
+	// small reads are served by the read-ahead buffer
+	p := make([]byte, 10)
+	for i := 0; i < 100; i++ {
+		n, err := r.Read(p)
+		Nil(t, err)
+		Equal(t, bytes.Equal(p[:n], content[i*10:i*10+n]), true)
+	}
+	Equal(t, atomic.LoadInt32(&gets), int32(1))
+
+	off, err := r.Seek(-100, io.SeekEnd)
+	Nil(t, err)
+	Equal(t, off, int64(len(content)-100))
+	b, err := ioutil.ReadAll(r)
+	Nil(t, err)
+	Equal(t, bytes.Equal(b, content[len(content)-100:]), true)
+
+	_, err = r.Seek(0, io.SeekStart)
+	Nil(t, err)
+	b, err = ioutil.ReadAll(r)
+	Nil(t, err)
+	Equal(t, bytes.Equal(b, content), true)
+
+	p = make([]byte, 1000)
+	n, err := r.ReadAt(p, 5000)
+	Nil(t, err)
+	Equal(t, n, 1000)
+	Equal(t, bytes.Equal(p, content[5000:6000]), true)
+	n, err = r.ReadAt(p, int64(len(content)-10))
+	Equal(t, err, io.EOF)
+	Equal(t, n, 10)
+	Equal(t, bytes.Equal(p[:n], content[len(content)-10:]), true)
+	_, err = r.ReadAt(p, int64(len(content)))
+	Equal(t, err, io.EOF)
+	_, err = r.Seek(-1, io.SeekStart)
+	NotNil(t, err)
+
+	Nil(t, r.Close())
+	_, err = r.Read(p)
+	Equal(t, err, os.ErrClosed)
+
+	_, err = fake.OpenObject("/missing")
+	Equal(t, IsNotExist(err), true)
+	_, err = fake.OpenObject("/dir")
+	NotNil(t, err)
+}
+
+func TestObjectReaderChanged(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	bucket.put("/media", []byte("hello world"))
+	r, err := fake.OpenObject("/media")
+	Nil(t, err)
+	defer r.Close()
+
+	bucket.put("/media", []byte("hello"))
+	_, err = ioutil.ReadAll(r)
+	NotNil(t, err)
+}
+
+func TestObjectReaderZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := map[string]string{"a.txt": "aaa", "b/c.txt": "ccc"}
+	for name, data := range files {
+		w, err := zw.Create(name)
+		Nil(t, err)
+		w.Write([]byte(data))
+	}
+	Nil(t, zw.Close())
+
+	fake, bucket := NewFakeBucketUpYun(t)
+	bucket.put("/archive.zip", buf.Bytes())
+	r, err := fake.OpenObject("/archive.zip")
+	Nil(t, err)
+	defer r.Close()
+
+	zr, err := zip.NewReader(r, r.Size())
+	Nil(t, err)
+	Equal(t, len(zr.File), len(files))
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		Nil(t, err)
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		Nil(t, err)
+		Equal(t, string(b), files[f.Name])
+	}
+}
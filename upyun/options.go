@@ -0,0 +1,263 @@
+package upyun
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Option configures an UpYun created by New.
+type Option func(*options) error
+
+type options struct {
+	config      UpYunConfig
+	httpc       *http.Client
+	deprecated  bool
+	recoder     *ResumeRecoder
+	logger      Logger
+	metrics     MetricsCollector
+	tracer      Tracer
+	middlewares []Middleware
+}
+
+// New creates an UpYun from options, e.g.
+//
+//	up, err := upyun.New(
+//		upyun.WithBucket("bucket"),
+//		upyun.WithCredentials("operator", "password"),
+//		upyun.WithTimeout(30*time.Second),
+//	)
+//
+// The resulting config is validated, the error is a *ConfigError if a
+// field is invalid.
+func New(opts ...Option) (*UpYun, error) {
+	o := &options{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.config.Validate(); err != nil {
+		return nil, err
+	}
+
+	up := NewUpYun(&o.config)
+	if o.httpc != nil {
+		up.SetHTTPClient(o.httpc)
+	}
+	if o.deprecated {
+		up.UseDeprecatedApi()
+	}
+	if o.recoder != nil {
+		up.SetBreakPoint(o.recoder)
+	}
+	if o.logger != nil {
+		up.SetLogger(o.logger)
+	}
+	if o.metrics != nil {
+		up.SetMetricsCollector(o.metrics)
+	}
+	if o.tracer != nil {
+		up.SetTracer(o.tracer)
+	}
+	up.Use(o.middlewares...)
+	return up, nil
+}
+
+// WithConfig starts from config, options after it override its fields.
+func WithConfig(config *UpYunConfig) Option {
+	return func(o *options) error {
+		o.config = *config
+		return nil
+	}
+}
+
+// WithProfile loads the config by LoadConfig(file, profile).
+func WithProfile(file, profile string) Option {
+	return func(o *options) error {
+		config, err := LoadConfig(file, profile)
+		if err != nil {
+			return err
+		}
+		o.config = *config
+		return nil
+	}
+}
+
+func WithBucket(bucket string) Option {
+	return func(o *options) error {
+		o.config.Bucket = bucket
+		return nil
+	}
+}
+
+// WithCredentials sets the operator and its plaintext password.
+func WithCredentials(operator, password string) Option {
+	return func(o *options) error {
+		o.config.Operator = operator
+		o.config.Password = password
+		o.config.PasswordMD5 = ""
+		return nil
+	}
+}
+
+func WithCredentialsProvider(provider CredentialsProvider) Option {
+	return func(o *options) error {
+		o.config.CredentialsProvider = provider
+		return nil
+	}
+}
+
+// WithEndpoints sets the endpoints of a logical host, see UpYunConfig.Endpoints.
+func WithEndpoints(host string, addrs ...string) Option {
+	return func(o *options) error {
+		endpoints := make(map[string][]string, len(o.config.Endpoints)+1)
+		for k, v := range o.config.Endpoints {
+			endpoints[k] = v
+		}
+		endpoints[host] = addrs
+		o.config.Endpoints = endpoints
+		return nil
+	}
+}
+
+func WithScheme(scheme string) Option {
+	return func(o *options) error {
+		o.config.Scheme = scheme
+		return nil
+	}
+}
+
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) error {
+		o.config.UserAgent = userAgent
+		return nil
+	}
+}
+
+func WithRetryPolicy(policy *RetryPolicy) Option {
+	return func(o *options) error {
+		o.config.RetryPolicy = policy
+		return nil
+	}
+}
+
+func WithCircuitBreaker(breaker *CircuitBreaker) Option {
+	return func(o *options) error {
+		o.config.CircuitBreaker = breaker
+		return nil
+	}
+}
+
+// WithRateLimits shares limits with the other clients created with it.
+func WithRateLimits(limits *RateLimits) Option {
+	return func(o *options) error {
+		o.config.RateLimits = limits
+		return nil
+	}
+}
+
+// WithTimeout sets UpYunConfig.Timeout, it has no effect with WithHTTPClient.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		o.config.Timeout = timeout
+		return nil
+	}
+}
+
+// WithConnectTimeout sets UpYunConfig.ConnectTimeout, it has no effect
+// with WithHTTPClient.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		o.config.ConnectTimeout = timeout
+		return nil
+	}
+}
+
+// WithTLSConfig has no effect with WithHTTPClient.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *options) error {
+		o.config.TLSConfig = config
+		return nil
+	}
+}
+
+// WithCAFile trusts the PEM encoded certificates in file, in addition to
+// the system ones. It has no effect with WithHTTPClient.
+func WithCAFile(file string) Option {
+	return func(o *options) error {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return &ConfigError{Source: file, Field: "ca_file", Reason: err.Error()}
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return &ConfigError{Source: file, Field: "ca_file", Reason: "no PEM certificate found"}
+		}
+		tlsConfig := &tls.Config{}
+		if o.config.TLSConfig != nil {
+			tlsConfig = o.config.TLSConfig.Clone()
+		}
+		tlsConfig.RootCAs = pool
+		o.config.TLSConfig = tlsConfig
+		return nil
+	}
+}
+
+func WithHTTPClient(httpc *http.Client) Option {
+	return func(o *options) error {
+		if httpc == nil {
+			return &ConfigError{Field: "http_client", Reason: "must not be nil"}
+		}
+		o.httpc = httpc
+		return nil
+	}
+}
+
+func WithDeprecatedAPI() Option {
+	return func(o *options) error {
+		o.deprecated = true
+		return nil
+	}
+}
+
+func WithBreakPoint(recoder *ResumeRecoder) Option {
+	return func(o *options) error {
+		o.recoder = recoder
+		return nil
+	}
+}
+
+func WithLogger(logger Logger) Option {
+	return func(o *options) error {
+		o.logger = logger
+		return nil
+	}
+}
+
+func WithMetricsCollector(c MetricsCollector) Option {
+	return func(o *options) error {
+		o.metrics = c
+		return nil
+	}
+}
+
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) error {
+		o.tracer = tracer
+		return nil
+	}
+}
+
+// WithMiddleware may be given several times, see Use.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(o *options) error {
+		o.middlewares = append(o.middlewares, mw...)
+		return nil
+	}
+}
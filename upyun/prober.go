@@ -0,0 +1,262 @@
+package upyun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultRESTEndpoints are the access points of the REST API:
+// v0 auto, v1 telecom, v2 unicom and v3 mobile.
+var DefaultRESTEndpoints = []string{
+	"v0.api.upyun.com",
+	"v1.api.upyun.com",
+	"v2.api.upyun.com",
+	"v3.api.upyun.com",
+}
+
+// ProbeFunc measures the latency of an endpoint.
+type ProbeFunc func(ctx context.Context, addr string) (time.Duration, error)
+
+// EndpointProberConfig provides a configuration to StartEndpointProber.
+type EndpointProberConfig struct {
+	// Host is the logical host, default "v0.api.upyun.com"
+	Host string
+	// Candidates default to DefaultRESTEndpoints, or to the Endpoints of
+	// Host in UpYunConfig if there are.
+	Candidates []string
+	// Interval between two rounds of probing, default 5 minutes.
+	// A negative value disables periodic probing.
+	Interval time.Duration
+	// Timeout of probing a single endpoint, default 5 seconds
+	Timeout time.Duration
+	// Probe defaults to the round-trip time of an unsigned HEAD request.
+	Probe ProbeFunc
+	// ProbeObject, if set, is an object of the bucket whose first
+	// ProbeBytes are downloaded from every endpoint which passes Probe, to
+	// measure its throughput. The endpoints are then ordered by throughput
+	// rather than latency.
+	ProbeObject string
+	// ProbeBytes defaults to DefaultProbeBytes
+	ProbeBytes int64
+}
+
+// DefaultProbeBytes is the size of the throughput sample.
+const DefaultProbeBytes = 256 * 1024
+
+// ProbeResult is the result of probing one endpoint.
+type ProbeResult struct {
+	Addr    string
+	Latency time.Duration
+	// Throughput is in bytes per second, 0 unless ProbeObject is set.
+	Throughput float64
+	Err        error
+	ProbedAt   time.Time
+}
+
+// EndpointProber measures the candidate endpoints of a host and lets
+// requests go to the fastest healthy one.
+type EndpointProber struct {
+	up     *UpYun
+	config EndpointProberConfig
+	group  *endpointGroup
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu      sync.Mutex
+	results []ProbeResult
+	pinned  string
+}
+
+// StartEndpointProber probes the candidates once before returning, then
+// periodically in background until Stop is called.
+func (up *UpYun) StartEndpointProber(config *EndpointProberConfig) *EndpointProber {
+	c := *config
+	if c.Host == "" {
+		c.Host = "v0.api.upyun.com"
+	}
+	if len(c.Candidates) == 0 {
+		c.Candidates = up.Endpoints[c.Host]
+	}
+	if len(c.Candidates) == 0 {
+		c.Candidates = DefaultRESTEndpoints
+	}
+	if c.Interval == 0 {
+		c.Interval = 5 * time.Minute
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.ProbeBytes <= 0 {
+		c.ProbeBytes = DefaultProbeBytes
+	}
+
+	p := &EndpointProber{
+		up:     up,
+		config: c,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if c.Probe == nil {
+		p.config.Probe = p.probeHEAD
+	}
+
+	up.endpointMu.Lock()
+	p.group = up.endpointGroups[c.Host]
+	if p.group == nil || !p.group.hasAll(c.Candidates) {
+		p.group = newEndpointGroup(c.Candidates, up.circuitBreaker())
+		up.endpointGroups[c.Host] = p.group
+	}
+	up.endpointMu.Unlock()
+
+	p.ProbeNow(context.Background())
+	if c.Interval > 0 {
+		go p.loop()
+	} else {
+		close(p.done)
+	}
+	return p
+}
+
+func (p *EndpointProber) loop() {
+	defer close(p.done)
+	t := time.NewTicker(p.config.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-t.C:
+			p.ProbeNow(context.Background())
+		}
+	}
+}
+
+// Stop stops periodic probing, the last order of endpoints is kept.
+func (p *EndpointProber) Stop() {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	<-p.done
+}
+
+// ProbeNow probes all candidates concurrently and reorders the endpoints
+// by latency, or by throughput if ProbeObject is set, failed ones last.
+func (p *EndpointProber) ProbeNow(ctx context.Context) []ProbeResult {
+	results := make([]ProbeResult, len(p.config.Candidates))
+	var wg sync.WaitGroup
+	for i, addr := range p.config.Candidates {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+			defer cancel()
+			r := ProbeResult{Addr: addr}
+			r.Latency, r.Err = p.config.Probe(pctx, addr)
+			if r.Err == nil && p.config.ProbeObject != "" {
+				r.Throughput, r.Err = p.probeThroughput(pctx, addr)
+			}
+			r.ProbedAt = time.Now()
+			results[i] = r
+		}(i, addr)
+	}
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Err == nil) != (results[j].Err == nil) {
+			return results[i].Err == nil
+		}
+		if results[i].Throughput != results[j].Throughput {
+			return results[i].Throughput > results[j].Throughput
+		}
+		return results[i].Latency < results[j].Latency
+	})
+
+	p.mu.Lock()
+	p.results = results
+	p.mu.Unlock()
+	p.apply()
+
+	p.up.log().Debug("upyun endpoints probed", "host", p.config.Host, "fastest", results[0].Addr,
+		"latency", results[0].Latency, "throughput", results[0].Throughput)
+	return append([]ProbeResult(nil), results...)
+}
+
+// Results returns the latest probe results, fastest first.
+func (p *EndpointProber) Results() []ProbeResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ProbeResult(nil), p.results...)
+}
+
+// Pin makes addr the preferred endpoint regardless of the probe results,
+// an empty addr goes back to the fastest one. addr must be one of the
+// endpoints of the host.
+func (p *EndpointProber) Pin(addr string) error {
+	if addr != "" && !p.group.hasAll([]string{addr}) {
+		return fmt.Errorf("pin %s: not an endpoint of %s", addr, p.config.Host)
+	}
+	p.mu.Lock()
+	p.pinned = addr
+	p.mu.Unlock()
+	p.apply()
+	return nil
+}
+
+func (p *EndpointProber) apply() {
+	p.mu.Lock()
+	order := make([]string, 0, len(p.results)+1)
+	if p.pinned != "" {
+		order = append(order, p.pinned)
+	}
+	for _, r := range p.results {
+		order = append(order, r.Addr)
+	}
+	p.mu.Unlock()
+	p.group.reorder(order)
+}
+
+func (p *EndpointProber) probeHEAD(ctx context.Context, addr string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", fmt.Sprintf("%s://%s/", p.up.Scheme, addr), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", p.up.UserAgent)
+	start := time.Now()
+	resp, err := p.up.httpClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	// any response, even 401, means the endpoint is serving
+	return time.Since(start), nil
+}
+
+// probeThroughput times a ranged GET of ProbeObject from addr.
+func (p *EndpointProber) probeThroughput(ctx context.Context, addr string) (float64, error) {
+	start := time.Now()
+	resp, err := p.up.doRESTRequest(ctx, &restReqConfig{
+		op:       "probe",
+		method:   "GET",
+		uri:      p.config.ProbeObject,
+		headers:  map[string]string{"Range": fmt.Sprintf("bytes=0-%d", p.config.ProbeBytes-1)},
+		maxTries: 1,
+		endpoint: addr,
+	})
+	if err != nil {
+		return 0, errorOperation("probe "+addr, err)
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(ioutil.Discard, resp.Body)
+	if err != nil {
+		return 0, errorOperation("probe "+addr, err)
+	}
+	return float64(n) / time.Since(start).Seconds(), nil
+}
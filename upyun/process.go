@@ -1,14 +1,13 @@
 package upyun
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"path"
 	"strings"
-	"time"
 )
 
 type CommitTasksConfig struct {
@@ -37,6 +36,13 @@ type SyncCommonTask struct {
 }
 
 func (up *UpYun) CommitTasks(config *CommitTasksConfig) (taskIds []string, err error) {
+	return up.CommitTasksWithContext(context.Background(), config)
+}
+
+func (up *UpYun) CommitTasksWithContext(ctx context.Context, config *CommitTasksConfig) (taskIds []string, err error) {
+	ctx, span := up.startSpan(ctx, "commit tasks", "upyun.app_name", config.AppName, "upyun.tasks", len(config.Tasks))
+	defer func() { span.End(err) }()
+
 	b, err := json.Marshal(config.Tasks)
 	if err != nil {
 		return nil, err
@@ -54,16 +60,20 @@ func (up *UpYun) CommitTasks(config *CommitTasksConfig) (taskIds []string, err e
 		kwargs["accept"] = config.Accept
 	}
 
-	err = up.doProcessRequest("POST", "/pretreatment/", kwargs, &taskIds)
+	err = up.doProcessRequest(ctx, "POST", "/pretreatment/", kwargs, &taskIds)
 	return
 }
 
 func (up *UpYun) GetProgress(taskIds []string) (result map[string]int, err error) {
+	return up.GetProgressWithContext(context.Background(), taskIds)
+}
+
+func (up *UpYun) GetProgressWithContext(ctx context.Context, taskIds []string) (result map[string]int, err error) {
 	kwargs := map[string]string{
 		"task_ids": strings.Join(taskIds, ","),
 	}
 	v := map[string]map[string]int{}
-	err = up.doProcessRequest("GET", "/status/", kwargs, &v)
+	err = up.doProcessRequest(ctx, "GET", "/status/", kwargs, &v)
 	if err != nil {
 		return
 	}
@@ -75,11 +85,15 @@ func (up *UpYun) GetProgress(taskIds []string) (result map[string]int, err error
 }
 
 func (up *UpYun) GetResult(taskIds []string) (result map[string]interface{}, err error) {
+	return up.GetResultWithContext(context.Background(), taskIds)
+}
+
+func (up *UpYun) GetResultWithContext(ctx context.Context, taskIds []string) (result map[string]interface{}, err error) {
 	kwargs := map[string]string{
 		"task_ids": strings.Join(taskIds, ","),
 	}
 	v := map[string]map[string]interface{}{}
-	err = up.doProcessRequest("GET", "/result/", kwargs, &v)
+	err = up.doProcessRequest(ctx, "GET", "/result/", kwargs, &v)
 	if err != nil {
 		return
 	}
@@ -90,7 +104,7 @@ func (up *UpYun) GetResult(taskIds []string) (result map[string]interface{}, err
 	return nil, fmt.Errorf("no tasks")
 }
 
-func (up *UpYun) doProcessRequest(method, uri string,
+func (up *UpYun) doProcessRequest(ctx context.Context, method, uri string,
 	kwargs map[string]string, v interface{}) error {
 	if _, ok := kwargs["service"]; !ok {
 		kwargs["service"] = up.Bucket
@@ -101,28 +115,42 @@ func (up *UpYun) doProcessRequest(method, uri string,
 	}
 
 	headers := make(map[string]string)
-	headers["Date"] = makeRFC1123Date(time.Now())
 	headers["Content-Type"] = "application/x-www-form-urlencoded"
-	if up.deprecated {
-		headers["Authorization"] = up.MakeProcessAuth(kwargs)
-	} else {
-		headers["Authorization"] = up.MakeUnifiedAuth(&UnifiedAuthConfig{
-			Method:  method,
-			Uri:     uri,
-			DateStr: headers["Date"],
-		})
+	sign := func(headers map[string]string) {
+		if up.isDeprecated() {
+			headers["Authorization"] = up.MakeProcessAuth(kwargs)
+		} else {
+			headers["Authorization"] = up.MakeUnifiedAuth(&UnifiedAuthConfig{
+				Method:  method,
+				Uri:     uri,
+				DateStr: headers["Date"],
+			})
+		}
 	}
 
 	var resp *http.Response
 	var err error
-	endpoint := up.doGetEndpoint("p0.api.upyun.com")
-	rawurl := fmt.Sprintf("http://%s%s", endpoint, uri)
 	switch method {
 	case "GET":
-		resp, err = up.doHTTPRequest(method, rawurl, headers, nil)
+		resp, err = up.doHTTPRequest(ctx, &httpReqConfig{
+			op:      "process",
+			method:  method,
+			host:    "p0.api.upyun.com",
+			uri:     uri,
+			headers: headers,
+			sign:    sign,
+		})
 	case "POST":
 		payload := encodeQueryToPayload(kwargs)
-		resp, err = up.doHTTPRequest(method, rawurl, headers, bytes.NewBufferString(payload))
+		resp, err = up.doHTTPRequest(ctx, &httpReqConfig{
+			op:      "process",
+			method:  method,
+			host:    "p0.api.upyun.com",
+			uri:     uri,
+			headers: headers,
+			sign:    sign,
+			body:    strings.NewReader(payload),
+		})
 	default:
 		return fmt.Errorf("Unknown method")
 	}
@@ -141,6 +169,10 @@ func (up *UpYun) doProcessRequest(method, uri string,
 }
 
 func (up *UpYun) CommitSyncTasks(commitTask interface{}) (result map[string]interface{}, err error) {
+	return up.CommitSyncTasksWithContext(context.Background(), commitTask)
+}
+
+func (up *UpYun) CommitSyncTasksWithContext(ctx context.Context, commitTask interface{}) (result map[string]interface{}, err error) {
 	var kwargs map[string]interface{}
 	var uri string
 	var payload string
@@ -186,28 +218,35 @@ func (up *UpYun) CommitSyncTasks(commitTask interface{}) (result map[string]inte
 		return nil, fmt.Errorf("can't encode the json")
 	}
 	payload = string(body)
-	return up.doSyncProcessRequest("POST", uri, payload)
+	return up.doSyncProcessRequest(ctx, "POST", uri, payload)
 }
 
-func (up *UpYun) doSyncProcessRequest(method, uri string, payload string) (map[string]interface{}, error) {
+func (up *UpYun) doSyncProcessRequest(ctx context.Context, method, uri string, payload string) (map[string]interface{}, error) {
 	headers := make(map[string]string)
-	headers["Date"] = makeRFC1123Date(time.Now())
 	headers["Content-Type"] = "application/json"
 	headers["Content-MD5"] = md5Str(payload)
-	headers["Authorization"] = up.MakeUnifiedAuth(&UnifiedAuthConfig{
-		Method:     method,
-		Uri:        uri,
-		DateStr:    headers["Date"],
-		ContentMD5: headers["Content-MD5"],
-	})
+	sign := func(headers map[string]string) {
+		headers["Authorization"] = up.MakeUnifiedAuth(&UnifiedAuthConfig{
+			Method:     method,
+			Uri:        uri,
+			DateStr:    headers["Date"],
+			ContentMD5: headers["Content-MD5"],
+		})
+	}
 
 	var resp *http.Response
 	var err error
-	endpoint := up.doGetEndpoint("p1.api.upyun.com")
-	rawurl := fmt.Sprintf("http://%s%s", endpoint, uri)
 	switch method {
 	case "POST":
-		resp, err = up.doHTTPRequest(method, rawurl, headers, strings.NewReader(payload))
+		resp, err = up.doHTTPRequest(ctx, &httpReqConfig{
+			op:      "sync process",
+			method:  method,
+			host:    "p1.api.upyun.com",
+			uri:     uri,
+			headers: headers,
+			sign:    sign,
+			body:    strings.NewReader(payload),
+		})
 	default:
 		return nil, fmt.Errorf("Unknown method")
 	}
@@ -224,7 +263,16 @@ func (up *UpYun) doSyncProcessRequest(method, uri string, payload string) (map[s
 	var v map[string]interface{}
 	err = json.Unmarshal(b, &v)
 	if err != nil {
-		fmt.Println("can't unmarshal the data", string(b))
+		// the body may be large or carry the processed data
+		head := b
+		if len(head) > maxLoggedBody {
+			head = head[:maxLoggedBody]
+		}
+		up.log().Debug("can't unmarshal the data", "op", "sync process", "body_size", len(b),
+			"body_head", string(head))
 	}
 	return v, err
 }
+
+// maxLoggedBody is the most bytes of a response body written to the log.
+const maxLoggedBody = 256
@@ -21,6 +21,7 @@ var (
 )
 
 func TestSpider(t *testing.T) {
+	requireLive(t)
 	task := map[string]interface{}{
 		"url":     MP4_URL,
 		"save_as": MP4_SAVE_AS,
@@ -36,6 +37,7 @@ func TestSpider(t *testing.T) {
 }
 
 func TestNagaCommit(t *testing.T) {
+	requireLive(t)
 	task := map[string]interface{}{
 		"type":   "video",
 		"avopts": "/f/mp4",
@@ -58,12 +60,14 @@ func TestNagaCommit(t *testing.T) {
 }
 
 func TestNagaProgress(t *testing.T) {
+	requireLive(t)
 	res, err := up.GetProgress(MP4_TASK_IDS)
 	Nil(t, err)
 	Equal(t, len(res), 2)
 }
 
 func TestNagaResult(t *testing.T) {
+	requireLive(t)
 	res, err := up.GetResult(MP4_TASK_IDS)
 	Nil(t, err)
 	Equal(t, len(res), 2)
@@ -71,6 +75,7 @@ func TestNagaResult(t *testing.T) {
 
 //由于是异步操作，不能确保文件已存在
 func TestImgaudit(t *testing.T) {
+	requireLive(t)
 	task := map[string]interface{}{
 		"url":     JPG_URL,
 		"save_as": JPG_SOURCE,
@@ -101,6 +106,7 @@ func TestImgaudit(t *testing.T) {
 
 //由于是异步操作，不能确保文件已存在
 func TestVideoaudit(t *testing.T) {
+	requireLive(t)
 	task := map[string]interface{}{
 		"url":     MP4_URL,
 		"save_as": MP4_SOURCE,
@@ -153,6 +159,7 @@ func TestVideoaudit(t *testing.T) {
 // }
 
 func TestFaceDetect(t *testing.T) {
+	requireLive(t)
 	resp, err := http.Get(FACE_URL + "!/face/detection")
 	Nil(t, err)
 	defer resp.Body.Close()
@@ -0,0 +1,301 @@
+package upyun
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressEventType is the kind of a ProgressEvent.
+type ProgressEventType int
+
+const (
+	// ProgressStarted is sent once before the first request.
+	ProgressStarted ProgressEventType = iota
+	// ProgressTransferred is sent while bytes are sent or received, at most
+	// once per interval, see DefaultProgressInterval.
+	ProgressTransferred
+	// ProgressPartCompleted is sent after each part of a multipart upload.
+	ProgressPartCompleted
+	// ProgressRetry is sent before a request or a part is sent again, the
+	// bytes of the failed attempt are no longer counted.
+	ProgressRetry
+	// ProgressCompleted or ProgressFailed is the last event.
+	ProgressCompleted
+	ProgressFailed
+)
+
+func (t ProgressEventType) String() string {
+	switch t {
+	case ProgressStarted:
+		return "started"
+	case ProgressTransferred:
+		return "transferred"
+	case ProgressPartCompleted:
+		return "part completed"
+	case ProgressRetry:
+		return "retry"
+	case ProgressCompleted:
+		return "completed"
+	case ProgressFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// DefaultProgressInterval is the least interval between two
+// ProgressTransferred events.
+const DefaultProgressInterval = 200 * time.Millisecond
+
+type ProgressEvent struct {
+	Type ProgressEventType
+	Path string
+	// Transferred is the number of bytes sent or received so far.
+	Transferred int64
+	// Total is the number of bytes to transfer, -1 if it is unknown. It is
+	// known for a Get once the response is received.
+	Total int64
+	// PartID is the part of ProgressPartCompleted and of a retried part,
+	// -1 otherwise.
+	PartID int
+	// Attempt is the number of the failed attempt of ProgressRetry.
+	Attempt int
+	// Err is the error of ProgressRetry and ProgressFailed.
+	Err error
+}
+
+// ProgressListener receives the progress of an operation. It is called
+// synchronously, one event at a time, so it should return quickly.
+type ProgressListener func(event ProgressEvent)
+
+// progressTracker counts the bytes of an operation, which may consist of
+// several requests, e.g. the parts of ResumePut. The methods are no-ops on
+// a nil tracker.
+type progressTracker struct {
+	mu       sync.Mutex
+	listener ProgressListener
+	interval time.Duration
+	path     string
+	total    int64
+	// base is the bytes of the finished requests, cur of the current one
+	base, cur int64
+	// attempt tells the bytes of a stale attempt from the current ones
+	attempt  int
+	last     time.Time
+	reported int64
+}
+
+type progressKey struct{}
+
+// startProgress returns ctx carrying a tracker which reports to listener,
+// the tracker is nil if listener is.
+func startProgress(ctx context.Context, listener ProgressListener, interval time.Duration,
+	path string, total int64) (context.Context, *progressTracker) {
+	if listener == nil {
+		return ctx, nil
+	}
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	t := &progressTracker{listener: listener, interval: interval, path: path, total: total, reported: -1}
+	t.mu.Lock()
+	t.emit(ProgressEvent{Type: ProgressStarted})
+	t.mu.Unlock()
+	return context.WithValue(ctx, progressKey{}, t), t
+}
+
+func progressFromContext(ctx context.Context) *progressTracker {
+	t, _ := ctx.Value(progressKey{}).(*progressTracker)
+	return t
+}
+
+// withoutProgress returns ctx for concurrent requests of an operation,
+// they are counted by the operation with transferred rather than one by
+// one.
+func withoutProgress(ctx context.Context) context.Context {
+	if ctx.Value(progressKey{}) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressKey{}, (*progressTracker)(nil))
+}
+
+// emit fills the common fields of e and sends it, t.mu is held.
+func (t *progressTracker) emit(e ProgressEvent) {
+	e.Path, e.Transferred, e.Total = t.path, t.base+t.cur, t.total
+	if e.Type != ProgressPartCompleted && e.Type != ProgressRetry {
+		e.PartID = -1
+	}
+	if e.Type == ProgressTransferred {
+		t.last, t.reported = time.Now(), e.Transferred
+	}
+	t.listener(e)
+}
+
+func (t *progressTracker) setTotal(total int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.total = total
+	t.mu.Unlock()
+}
+
+// skip counts n bytes transferred before, by an interrupted operation.
+func (t *progressTracker) skip(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.base += n
+	t.mu.Unlock()
+}
+
+// begin starts counting the bytes of a new request, those of an earlier
+// failed attempt are dropped.
+func (t *progressTracker) begin() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.attempt++
+	t.cur = 0
+	return t.attempt
+}
+
+func (t *progressTracker) add(attempt, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if attempt != t.attempt {
+		return
+	}
+	t.cur += int64(n)
+	if time.Since(t.last) >= t.interval || t.base+t.cur == t.total {
+		t.emit(ProgressEvent{Type: ProgressTransferred})
+	}
+}
+
+// transferred counts n bytes of concurrent requests, a negative n drops
+// the bytes of a failed one.
+func (t *progressTracker) transferred(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.base += n
+	if n > 0 && (time.Since(t.last) >= t.interval || t.base+t.cur == t.total) {
+		t.emit(ProgressEvent{Type: ProgressTransferred})
+	}
+}
+
+// commit keeps the bytes of the current request after it succeeded.
+func (t *progressTracker) commit() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.base += t.cur
+	t.cur = 0
+}
+
+func (t *progressTracker) retry(partID, attempt int, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cur = 0
+	t.emit(ProgressEvent{Type: ProgressRetry, PartID: partID, Attempt: attempt, Err: err})
+}
+
+func (t *progressTracker) partCompleted(partID int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.emit(ProgressEvent{Type: ProgressPartCompleted, PartID: partID})
+}
+
+// finish reports the bytes not reported yet and the end of the operation.
+func (t *progressTracker) finish(err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.base+t.cur != t.reported {
+		t.emit(ProgressEvent{Type: ProgressTransferred})
+	}
+	if err != nil {
+		t.emit(ProgressEvent{Type: ProgressFailed, Err: err})
+	} else {
+		t.emit(ProgressEvent{Type: ProgressCompleted})
+	}
+}
+
+// reader counts the bytes read from rc for the current request.
+func (t *progressTracker) reader(rc io.ReadCloser) io.ReadCloser {
+	if t == nil || rc == nil {
+		return rc
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &progressReader{ReadCloser: rc, t: t, attempt: t.attempt}
+}
+
+type progressReader struct {
+	io.ReadCloser
+	t       *progressTracker
+	attempt int
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.t.add(r.attempt, n)
+	}
+	return n, err
+}
+
+// readerSize returns the number of bytes left in r, or the Content-Length
+// of headers, -1 if neither is known.
+func readerSize(r io.Reader, headers map[string]string) int64 {
+	for k, v := range headers {
+		if strings.EqualFold(k, "Content-Length") {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return n
+			}
+		}
+	}
+	switch v := r.(type) {
+	case *os.File:
+		fInfo, err := v.Stat()
+		if err != nil {
+			return -1
+		}
+		offset, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return fInfo.Size() - offset
+	case UpYunPutReader:
+		return int64(v.Len())
+	case *bytes.Buffer:
+		return int64(v.Len())
+	case *bytes.Reader:
+		return int64(v.Len())
+	case *strings.Reader:
+		return int64(v.Len())
+	case *io.LimitedReader:
+		return v.N
+	}
+	return -1
+}
@@ -0,0 +1,187 @@
+package upyun
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type progressRecorder struct {
+	mu     sync.Mutex
+	events []ProgressEvent
+}
+
+func (r *progressRecorder) listen(e ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *progressRecorder) count(typ ProgressEventType) int {
+	n := 0
+	for _, e := range r.events {
+		if e.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+// check verifies the first and the last events, and that nothing is
+// counted twice.
+func (r *progressRecorder) check(t *testing.T, last ProgressEventType, transferred, total int64) {
+	t.Helper()
+	Equal(t, len(r.events) >= 2, true)
+	Equal(t, r.events[0].Type, ProgressStarted)
+	end := r.events[len(r.events)-1]
+	Equal(t, end.Type, last)
+	Equal(t, end.Transferred, transferred)
+	Equal(t, end.Total, total)
+	var lastTransferred int64 = -1
+	for _, e := range r.events {
+		Equal(t, e.Transferred <= transferred, true)
+		if e.Type == ProgressTransferred {
+			lastTransferred = e.Transferred
+		}
+	}
+	Equal(t, lastTransferred, transferred)
+}
+
+func TestProgressPut(t *testing.T) {
+	bucket := newFakeBucket()
+	failed := false
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" && !failed {
+			// fail the first attempt after the body is received
+			failed = true
+			ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bucket.ServeHTTP(w, r)
+	})
+
+	content := bytes.Repeat([]byte("P"), 100*1024)
+	var rec progressRecorder
+	Nil(t, fake.Put(&PutObjectConfig{
+		Path:     "/progress",
+		Reader:   bytes.NewReader(content),
+		Progress: rec.listen,
+	}))
+	rec.check(t, ProgressCompleted, int64(len(content)), int64(len(content)))
+	Equal(t, rec.count(ProgressRetry), 1)
+	Equal(t, bytes.Equal(bucket.get("/progress"), content), true)
+}
+
+func TestProgressResumePut(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	content := bytes.Repeat([]byte("R"), minResumePutFileSize+DefaultPartSize/2)
+	fname := filepath.Join(t.TempDir(), "big")
+	Nil(t, ioutil.WriteFile(fname, content, 0644))
+
+	var rec progressRecorder
+	Nil(t, fake.Put(&PutObjectConfig{
+		Path:            "/progress",
+		LocalPath:       fname,
+		UseResumeUpload: true,
+		Progress:        rec.listen,
+	}))
+	rec.check(t, ProgressCompleted, int64(len(content)), int64(len(content)))
+	parts := (len(content) + DefaultPartSize - 1) / DefaultPartSize
+	Equal(t, rec.count(ProgressPartCompleted), parts)
+	Equal(t, len(bucket.get("/progress")), len(content))
+}
+
+func TestProgressGetAndForm(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	content := bytes.Repeat([]byte("G"), 300*1024)
+	bucket.put("/progress", content)
+
+	var rec progressRecorder
+	var buf bytes.Buffer
+	_, err := fake.Get(&GetObjectConfig{Path: "/progress", Writer: &buf, Progress: rec.listen})
+	Nil(t, err)
+	rec.check(t, ProgressCompleted, int64(len(content)), int64(len(content)))
+
+	rec = progressRecorder{}
+	_, err = fake.Get(&GetObjectConfig{Path: "/missing", Writer: &buf, Progress: rec.listen})
+	Equal(t, IsNotExist(err), true)
+	rec.check(t, ProgressFailed, 0, -1)
+	Equal(t, IsNotExist(rec.events[len(rec.events)-1].Err), true)
+
+	fname := filepath.Join(t.TempDir(), "form")
+	Nil(t, ioutil.WriteFile(fname, content, 0644))
+	rec = progressRecorder{}
+	_, err = fake.FormUpload(&FormUploadConfig{LocalPath: fname, SaveKey: "/form", Progress: rec.listen})
+	Nil(t, err)
+	end := rec.events[len(rec.events)-1]
+	Equal(t, end.Type, ProgressCompleted)
+	Equal(t, end.Transferred, end.Total)
+	Equal(t, end.Total > int64(len(content)), true)
+	Equal(t, end.Path, "/form")
+}
+
+func TestProgressUploadPart(t *testing.T) {
+	fake, _ := NewFakeBucketUpYun(t)
+	initResult, err := fake.InitMultipartUpload(&InitMultipartUploadConfig{
+		Path:     "/parts",
+		PartSize: DefaultPartSize,
+	})
+	Nil(t, err)
+
+	part := bytes.Repeat([]byte("U"), DefaultPartSize)
+	var rec progressRecorder
+	Nil(t, fake.UploadPart(initResult, &UploadPartConfig{
+		Reader:   bytes.NewReader(part),
+		PartSize: int64(len(part)),
+		PartID:   0,
+		Progress: rec.listen,
+	}))
+	rec.check(t, ProgressCompleted, int64(len(part)), int64(len(part)))
+}
+
+func TestProgressDownload(t *testing.T) {
+	bucket := newFakeBucket()
+	content := bytes.Repeat([]byte("D"), 300*1024)
+	bucket.put("/progress", content)
+	var mu sync.Mutex
+	cut := false
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		first := !cut && r.Header.Get("Range") == "bytes=65536-131071"
+		if first {
+			cut = true
+		}
+		mu.Unlock()
+		if first {
+			// send half of the range, then fail
+			w.Header().Set("Content-Range", "bytes 65536-131071/"+strconv.Itoa(len(content)))
+			w.Header().Set("Content-Length", "65536")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[:32*1024])
+			return
+		}
+		bucket.ServeHTTP(w, r)
+	})
+
+	var rec progressRecorder
+	fname := filepath.Join(t.TempDir(), "progress")
+	_, err := fake.Download(&DownloadConfig{
+		Path:      "/progress",
+		LocalPath: fname,
+		ChunkSize: 64 * 1024,
+		Progress:  rec.listen,
+	})
+	Nil(t, err)
+	rec.check(t, ProgressCompleted, int64(len(content)), int64(len(content)))
+	Equal(t, rec.count(ProgressRetry), 1)
+
+	rec = progressRecorder{}
+	_, err = fake.Download(&DownloadConfig{Path: "/missing", LocalPath: fname, Progress: rec.listen})
+	Equal(t, IsNotExist(err), true)
+	rec.check(t, ProgressFailed, 0, -1)
+}
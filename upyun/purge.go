@@ -1,33 +1,44 @@
 package upyun
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	URL "net/url"
 	"strings"
-	"time"
 )
 
 // TODO
 func (up *UpYun) Purge(urls []string) (fails []string, err error) {
-	purge := "http://purge.upyun.com/purge/"
-	date := makeRFC1123Date(time.Now())
+	return up.PurgeWithContext(context.Background(), urls)
+}
+
+func (up *UpYun) PurgeWithContext(ctx context.Context, urls []string) (fails []string, err error) {
 	purgeList := unescapeUri(strings.Join(urls, "\n"))
 
 	headers := map[string]string{
-		"Date": date,
-		"Authorization": up.MakePurgeAuth(&PurgeAuthConfig{
-			PurgeList: purgeList,
-			DateStr:   date,
-		}),
 		"Content-Type": "application/x-www-form-urlencoded;charset=utf-8",
 	}
+	sign := func(headers map[string]string) {
+		headers["Authorization"] = up.MakePurgeAuth(&PurgeAuthConfig{
+			PurgeList: purgeList,
+			DateStr:   headers["Date"],
+		})
+	}
 
 	form := make(URL.Values)
 	form.Add("purge", purgeList)
 
 	body := strings.NewReader(form.Encode())
-	resp, err := up.doHTTPRequest("POST", purge, headers, body)
+	resp, err := up.doHTTPRequest(ctx, &httpReqConfig{
+		op:      "purge",
+		method:  "POST",
+		host:    "purge.upyun.com",
+		uri:     "/purge/",
+		headers: headers,
+		sign:    sign,
+		body:    body,
+	})
 	if err != nil {
 		return fails, errorOperation("purge", err)
 	}
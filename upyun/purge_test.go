@@ -6,6 +6,7 @@ import (
 )
 
 func TestPurge(t *testing.T) {
+	requireLive(t)
 	fails, err := up.Purge([]string{
 		fmt.Sprintf("http://%s.b0.upaiyun.com/demo.jpg", up.Bucket),
 	})
@@ -0,0 +1,210 @@
+package upyun
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ByteRange is a range of bytes of an object. A negative Offset selects the
+// last -Offset bytes, a zero Length means up to the end of the object.
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+func (r ByteRange) String() string {
+	switch {
+	case r.Offset < 0:
+		return fmt.Sprintf("-%d", -r.Offset)
+	case r.Length <= 0:
+		return fmt.Sprintf("%d-", r.Offset)
+	default:
+		return fmt.Sprintf("%d-%d", r.Offset, r.Offset+r.Length-1)
+	}
+}
+
+// resolve returns the absolute [start, end) of r in an object of size total.
+func (r ByteRange) resolve(total int64) (start, end int64) {
+	if r.Offset < 0 {
+		start = total + r.Offset
+		if start < 0 {
+			start = 0
+		}
+		return start, total
+	}
+	start, end = r.Offset, total
+	if r.Length > 0 && start+r.Length < total {
+		end = start + r.Length
+	}
+	return start, end
+}
+
+// validateRanges checks that ranges are ascending and do not overlap, so
+// that they can be picked out of the response in one pass. A suffix range
+// or an open-ended one must be the last.
+func validateRanges(ranges []ByteRange) error {
+	var next int64
+	for i, r := range ranges {
+		invalid := func(reason string) error {
+			return fmt.Errorf("invalid range (offset %d, length %d): %s", r.Offset, r.Length, reason)
+		}
+		switch {
+		case r.Length < 0:
+			return invalid("negative length")
+		case r.Offset < 0 && r.Length != 0:
+			return invalid("a suffix range has no length")
+		case r.Offset >= 0 && r.Offset < next:
+			return invalid("ranges must be ascending and must not overlap")
+		case (r.Offset < 0 || r.Length == 0) && i < len(ranges)-1:
+			return invalid("a range up to the end must be the last one")
+		}
+		next = r.Offset + r.Length
+	}
+	return nil
+}
+
+func rangeHeader(ranges []ByteRange) string {
+	specs := make([]string, len(ranges))
+	for i, r := range ranges {
+		specs[i] = r.String()
+	}
+	return "bytes=" + strings.Join(specs, ",")
+}
+
+// parseContentRange parses "bytes start-end/total" into [start, end), total
+// is -1 if it is unknown.
+func parseContentRange(s string) (start, end, total int64, err error) {
+	invalid := fmt.Errorf("invalid Content-Range %q", s)
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, 0, invalid
+	}
+	s = strings.TrimPrefix(s, "bytes ")
+	i, j := strings.IndexByte(s, '-'), strings.IndexByte(s, '/')
+	if i < 0 || j < i {
+		return 0, 0, 0, invalid
+	}
+	if start, err = strconv.ParseInt(s[:i], 10, 64); err != nil {
+		return 0, 0, 0, invalid
+	}
+	if end, err = strconv.ParseInt(s[i+1:j], 10, 64); err != nil || end < start {
+		return 0, 0, 0, invalid
+	}
+	total = -1
+	if s[j+1:] != "*" {
+		if total, err = strconv.ParseInt(s[j+1:], 10, 64); err != nil || end >= total {
+			return 0, 0, 0, invalid
+		}
+	}
+	return start, end + 1, total, nil
+}
+
+// rangeWriter writes the requested ranges in order, picking them out of
+// the segments received, so that coalesced or ignored ranges are handled.
+type rangeWriter struct {
+	w      io.Writer
+	ranges []ByteRange
+	total  int64
+	next   int
+	// written is the end of the last range written
+	written int64
+}
+
+// copySegment copies the requested ranges within the segment [start, end)
+// read from r, end is math.MaxInt64 if the segment runs to an unknown end
+// of the object.
+func (rw *rangeWriter) copySegment(r io.Reader, start, end int64) error {
+	pos := start
+	for ; rw.next < len(rw.ranges); rw.next++ {
+		br := rw.ranges[rw.next]
+		total := rw.total
+		if total < 0 {
+			if br.Offset < 0 {
+				return errors.New("object size is unknown for a suffix range")
+			}
+			total = end
+		}
+		s, e := br.resolve(total)
+		if rw.next > 0 && s < rw.written {
+			// a suffix range of a small object
+			return fmt.Errorf("range %s overlaps the previous one", br)
+		}
+		if s < pos || e > end {
+			return nil
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, s-pos); err != nil {
+			return err
+		}
+		_, err := io.CopyN(rw.w, r, e-s)
+		if err == io.EOF && end == math.MaxInt64 {
+			// the object ends within the range
+			rw.next++
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		pos, rw.written = e, e
+	}
+	return nil
+}
+
+func (rw *rangeWriter) done() error {
+	if rw.next < len(rw.ranges) {
+		return fmt.Errorf("range %s is not satisfied by the response", rw.ranges[rw.next])
+	}
+	return nil
+}
+
+// copyRanges writes the ranges of resp to w and returns the total size of
+// the object, or -1 if it is unknown.
+func copyRanges(w io.Writer, resp *http.Response, ranges []ByteRange) (total int64, err error) {
+	rw := &rangeWriter{w: w, ranges: ranges, total: -1}
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// the Range header is ignored, the whole object is sent
+		rw.total = resp.ContentLength
+		end := rw.total
+		if end < 0 {
+			end = math.MaxInt64
+		}
+		err = rw.copySegment(resp.Body, 0, end)
+	case resp.StatusCode == http.StatusPartialContent && mediaType == "multipart/byteranges":
+		mr := multipart.NewReader(resp.Body, params["boundary"])
+		for err == nil {
+			var part *multipart.Part
+			if part, err = mr.NextPart(); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				break
+			}
+			var start, end int64
+			start, end, rw.total, err = parseContentRange(part.Header.Get("Content-Range"))
+			if err == nil {
+				err = rw.copySegment(part, start, end)
+			}
+		}
+	case resp.StatusCode == http.StatusPartialContent:
+		var start, end int64
+		start, end, rw.total, err = parseContentRange(resp.Header.Get("Content-Range"))
+		if err == nil {
+			err = rw.copySegment(resp.Body, start, end)
+		}
+	default:
+		err = fmt.Errorf("unexpected status %d of a range request", resp.StatusCode)
+	}
+	if err != nil {
+		return rw.total, err
+	}
+	return rw.total, rw.done()
+}
@@ -0,0 +1,127 @@
+package upyun
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestGetRanges(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	bucket.put("/log", []byte("0123456789abcdefghij"))
+
+	cases := []struct {
+		config *GetObjectConfig
+		want   string
+	}{
+		{&GetObjectConfig{Offset: 2, Length: 3}, "234"},
+		{&GetObjectConfig{Offset: 15}, "fghij"},
+		{&GetObjectConfig{Offset: -4}, "ghij"},
+		{&GetObjectConfig{Offset: 18, Length: 10}, "ij"},
+		{&GetObjectConfig{Ranges: []ByteRange{{0, 2}, {10, 3}, {-1, 0}}}, "01abcj"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		c.config.Path = "/log"
+		c.config.Writer = &buf
+		fInfo, err := fake.Get(c.config)
+		Nil(t, err)
+		Equal(t, buf.String(), c.want)
+		Equal(t, fInfo.Size, int64(20))
+	}
+
+	_, err := fake.Get(&GetObjectConfig{Path: "/log", Writer: &bytes.Buffer{}, Offset: 30})
+	Equal(t, checkStatusCode(err, http.StatusRequestedRangeNotSatisfiable), true)
+
+	_, err = fake.Get(&GetObjectConfig{Path: "/log", Writer: &bytes.Buffer{}, Offset: 1, Ranges: []ByteRange{{0, 1}}})
+	NotNil(t, err)
+
+	for _, ranges := range [][]ByteRange{
+		{{2, -1}},
+		{{-2, 1}},
+		{{5, 2}, {0, 2}},
+		{{0, 5}, {4, 2}},
+		{{0, 0}, {5, 2}},
+		{{-2, 0}, {5, 2}},
+	} {
+		_, err = fake.Get(&GetObjectConfig{Path: "/log", Writer: &bytes.Buffer{}, Ranges: ranges})
+		NotNil(t, err)
+	}
+	// the suffix overlaps the first range of a small object
+	_, err = fake.Get(&GetObjectConfig{Path: "/log", Writer: &bytes.Buffer{}, Ranges: []ByteRange{{0, 10}, {-15, 0}}})
+	NotNil(t, err)
+}
+
+func TestGetRangesIgnoredOrInvalid(t *testing.T) {
+	contentRange, chunked := "", false
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		if chunked {
+			// no Content-Length, the size of the object is unknown
+			w.Write([]byte("01234"))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("56789"))
+			return
+		}
+		if contentRange != "" {
+			w.Header().Set("Content-Range", contentRange)
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("234"))
+			return
+		}
+		// the Range header is ignored
+		w.Write([]byte("0123456789"))
+	})
+
+	var buf bytes.Buffer
+	fInfo, err := fake.Get(&GetObjectConfig{Path: "/a", Writer: &buf, Ranges: []ByteRange{{1, 2}, {5, 2}}})
+	Nil(t, err)
+	Equal(t, buf.String(), "1256")
+	Equal(t, fInfo.Size, int64(10))
+
+	chunked = true
+	for _, c := range []struct {
+		ranges []ByteRange
+		want   string
+	}{
+		{[]ByteRange{{3, 0}}, "3456789"},
+		{[]ByteRange{{1, 2}, {8, 5}}, "1289"},
+	} {
+		buf.Reset()
+		fInfo, err = fake.Get(&GetObjectConfig{Path: "/a", Writer: &buf, Ranges: c.ranges})
+		Nil(t, err)
+		Equal(t, buf.String(), c.want)
+		Equal(t, fInfo.Size, int64(-1))
+	}
+	_, err = fake.Get(&GetObjectConfig{Path: "/a", Writer: &buf, Ranges: []ByteRange{{1, 2}, {12, 0}}})
+	NotNil(t, err)
+	chunked = false
+
+	// a larger range than requested is fine
+	contentRange = "bytes 2-4/10"
+	buf.Reset()
+	_, err = fake.Get(&GetObjectConfig{Path: "/a", Writer: &buf, Offset: 3, Length: 2})
+	Nil(t, err)
+	Equal(t, buf.String(), "34")
+
+	_, err = fake.Get(&GetObjectConfig{Path: "/a", Writer: &buf, Offset: 1, Length: 2})
+	NotNil(t, err)
+
+	contentRange = "bytes 2-4"
+	_, err = fake.Get(&GetObjectConfig{Path: "/a", Writer: &buf, Offset: 2, Length: 3})
+	NotNil(t, err)
+}
+
+func TestParseContentRange(t *testing.T) {
+	start, end, total, err := parseContentRange("bytes 0-99/1000")
+	Nil(t, err)
+	Equal(t, []int64{start, end, total}, []int64{0, 100, 1000})
+
+	_, _, total, err = parseContentRange("bytes 5-9/*")
+	Nil(t, err)
+	Equal(t, total, int64(-1))
+
+	for _, s := range []string{"", "bytes */1000", "bytes 9-5/10", "bytes 0-10/10", "items 0-1/2"} {
+		_, _, _, err = parseContentRange(s)
+		NotNil(t, err)
+	}
+}
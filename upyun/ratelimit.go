@@ -0,0 +1,194 @@
+package upyun
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Operation classes of RateLimits.Requests.
+const (
+	// get, get info, list, usage and listing multipart uploads
+	OpClassRead = "read"
+	// put, mkdir, move, copy, multipart upload, form upload and modify metadata
+	OpClassWrite  = "write"
+	OpClassDelete = "delete"
+	// process, sync process and purge
+	OpClassProcess = "process"
+	// OpClassDefault applies to the classes without their own limiter
+	OpClassDefault = "*"
+)
+
+func opClass(op string) string {
+	switch opName(op) {
+	case "get", "get info", "list", "usage", "list multipart", "list multipart parts":
+		return OpClassRead
+	case "delete":
+		return OpClassDelete
+	case "process", "sync process", "purge":
+		return OpClassProcess
+	}
+	return OpClassWrite
+}
+
+// RateLimits throttles the requests of UpYun clients, the same limiters
+// can be shared by several clients to limit them as a whole.
+type RateLimits struct {
+	// Requests limits the requests per second of each operation class,
+	// every attempt of a retried request counts. It must not be modified
+	// once in use, adjust the limiters instead.
+	Requests map[string]*RateLimiter
+	// Upload limits the bytes per second of the request bodies of put,
+	// upload multipart and form upload.
+	Upload *RateLimiter
+	// Download limits the bytes per second of the response bodies of get.
+	Download *RateLimiter
+}
+
+func (l *RateLimits) requestLimiter(class string) *RateLimiter {
+	if r, ok := l.Requests[class]; ok {
+		return r
+	}
+	return l.Requests[OpClassDefault]
+}
+
+// RateLimiter is a token bucket, which is safe for concurrent use.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows rate tokens per second with bursts of at most burst
+// tokens. A rate <= 0 means no limit, a burst <= 0 defaults to one second
+// of tokens.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetRate(rate, burst)
+	return l
+}
+
+// SetRate changes the limit at runtime, waiters already sleeping are not
+// woken up early.
+func (l *RateLimiter) SetRate(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	first := l.last.IsZero()
+	l.refill(time.Now())
+	l.rate = rate
+	l.burst = float64(burst)
+	if l.burst <= 0 {
+		l.burst = math.Max(1, rate)
+	}
+	if first || l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// Rate returns the current limit.
+func (l *RateLimiter) Rate() (rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate, int(l.burst)
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() && l.rate > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
+
+// Wait blocks until n tokens are available or ctx is done. n may exceed
+// the burst, the debt is paid by the following callers.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if err := sleepWithContext(ctx, d); err != nil {
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// SetRateLimits replaces the rate limits at runtime, nil removes them.
+func (up *UpYun) SetRateLimits(limits *RateLimits) {
+	up.limitsMu.Lock()
+	up.RateLimits = limits
+	up.limitsMu.Unlock()
+}
+
+func (up *UpYun) rateLimits() *RateLimits {
+	up.limitsMu.RLock()
+	defer up.limitsMu.RUnlock()
+	return up.RateLimits
+}
+
+// limitRequest is the middleware which applies the rate limits.
+func (up *UpYun) limitRequest(next Handler) Handler {
+	return func(op string, req *http.Request) (*http.Response, error) {
+		limits := up.rateLimits()
+		if limits == nil {
+			return next(op, req)
+		}
+		if l := limits.requestLimiter(opClass(op)); l != nil {
+			if err := l.Wait(req.Context(), 1); err != nil {
+				return nil, err
+			}
+		}
+
+		name := opName(op)
+		if l := limits.Upload; l != nil && req.Body != nil &&
+			(name == "put" || name == "upload multipart" || name == "form") {
+			req.Body = &throttledReadCloser{ctx: req.Context(), ReadCloser: req.Body, limiter: l}
+		}
+		resp, err := next(op, req)
+		if l := limits.Download; l != nil && err == nil && name == "get" {
+			resp.Body = &throttledReadCloser{ctx: req.Context(), ReadCloser: resp.Body, limiter: l}
+		}
+		return resp, err
+	}
+}
+
+type throttledReadCloser struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *RateLimiter
+}
+
+func (r *throttledReadCloser) Read(p []byte) (int, error) {
+	rate, burst := r.limiter.Rate()
+	if rate <= 0 {
+		// no limit, e.g. after SetRate(0, 0)
+		return r.ReadCloser.Read(p)
+	}
+	// keep the waits short, so that throttling is smooth
+	if burst > 0 && len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := r.limiter.Wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,106 @@
+package upyun
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(100, 1)
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		Nil(t, l.Wait(context.Background(), 1))
+	}
+	Equal(t, time.Since(start) >= 90*time.Millisecond, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	NotNil(t, l.Wait(ctx, 100))
+
+	l.SetRate(0, 0)
+	start = time.Now()
+	Nil(t, l.Wait(context.Background(), 1<<20))
+	Equal(t, time.Since(start) < 10*time.Millisecond, true)
+}
+
+func TestRateLimitsRequestsShared(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("1")) }
+	a, b := NewFakeUpYun(t, h), NewFakeUpYun(t, h)
+	limits := &RateLimits{
+		Requests: map[string]*RateLimiter{OpClassRead: NewRateLimiter(50, 1)},
+	}
+	a.SetRateLimits(limits)
+	b.SetRateLimits(limits)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := a.Usage()
+		Nil(t, err)
+		_, err = b.Usage()
+		Nil(t, err)
+	}
+	// 6 requests, the first one is free
+	Equal(t, time.Since(start) >= 90*time.Millisecond, true)
+
+	// writes are not limited
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		Nil(t, a.Mkdir("/dir"))
+	}
+	Equal(t, time.Since(start) < 50*time.Millisecond, true)
+}
+
+func TestRateLimitsBandwidth(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 20*1024)
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			b, _ := ioutil.ReadAll(r.Body)
+			Equal(t, len(b), len(content))
+			return
+		}
+		w.Write(content)
+	})
+	fake.SetRateLimits(&RateLimits{
+		Upload:   NewRateLimiter(100*1024, 10*1024),
+		Download: NewRateLimiter(100*1024, 10*1024),
+	})
+
+	start := time.Now()
+	Nil(t, fake.Put(&PutObjectConfig{Path: "/a", Reader: bytes.NewReader(content)}))
+	Equal(t, time.Since(start) >= 90*time.Millisecond, true)
+
+	var buf bytes.Buffer
+	start = time.Now()
+	fInfo, err := fake.Get(&GetObjectConfig{Path: "/a", Writer: &buf})
+	Nil(t, err)
+	Equal(t, fInfo.Size, int64(len(content)))
+	Equal(t, time.Since(start) >= 90*time.Millisecond, true)
+}
+
+// readCounter counts the calls of Read
+type readCounter struct {
+	r     *bytes.Reader
+	reads int
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	r.reads++
+	return r.r.Read(p)
+}
+
+func (r *readCounter) Close() error { return nil }
+
+func TestRateLimiterUnlimitedReads(t *testing.T) {
+	l := NewRateLimiter(100, 0)
+	l.SetRate(0, 0)
+	cr := &readCounter{r: bytes.NewReader(make([]byte, 1<<20))}
+	b, err := ioutil.ReadAll(&throttledReadCloser{ctx: context.Background(), ReadCloser: cr, limiter: l})
+	Nil(t, err)
+	Equal(t, len(b), 1<<20)
+	// reads are not cut to the burst of an unlimited limiter
+	Equal(t, cr.reads < 100, true)
+}
@@ -1,5 +1,7 @@
 package upyun
 
+import "sync"
+
 type Recoder interface {
 	Set(breakpoint *BreakPointConfig) error
 
@@ -8,22 +10,58 @@ type Recoder interface {
 	Delete(uploadID string) error
 }
 
-var resumeRecode = make(map[string]*BreakPointConfig)
+var (
+	resumeRecodeMu sync.Mutex
+	resumeRecode   = make(map[string]*BreakPointConfig)
+)
 
+// ResumeRecoder keeps the breakpoints of interrupted resumable uploads in
+// memory, it is safe for concurrent use.
 type ResumeRecoder struct {
+	// UploadID is the upload id of the latest resumable upload, it is only
+	// meaningful if one upload runs at a time. Use LastUploadID to read it
+	// while uploads are running, or PutObjectConfig.ResumeUploadID to
+	// resume a given upload.
 	UploadID string
+
+	mu sync.Mutex
 }
 
 func (recoder *ResumeRecoder) Get(uploadID string) (*BreakPointConfig, error) {
-	return resumeRecode[uploadID], nil
+	resumeRecodeMu.Lock()
+	defer resumeRecodeMu.Unlock()
+	if breakpoint, ok := resumeRecode[uploadID]; ok {
+		// callers may modify it while uploading
+		bp := *breakpoint
+		return &bp, nil
+	}
+	return nil, nil
 }
 
 func (recoder *ResumeRecoder) Set(breakpoint *BreakPointConfig) error {
-	resumeRecode[breakpoint.UploadID] = breakpoint
+	bp := *breakpoint
+	resumeRecodeMu.Lock()
+	resumeRecode[breakpoint.UploadID] = &bp
+	resumeRecodeMu.Unlock()
 	return nil
 }
 
 func (recoder *ResumeRecoder) Delete(uploadID string) error {
+	resumeRecodeMu.Lock()
 	delete(resumeRecode, uploadID)
+	resumeRecodeMu.Unlock()
 	return nil
 }
+
+// LastUploadID returns the upload id of the latest resumable upload.
+func (recoder *ResumeRecoder) LastUploadID() string {
+	recoder.mu.Lock()
+	defer recoder.mu.Unlock()
+	return recoder.UploadID
+}
+
+func (recoder *ResumeRecoder) setUploadID(uploadID string) {
+	recoder.mu.Lock()
+	recoder.UploadID = uploadID
+	recoder.mu.Unlock()
+}
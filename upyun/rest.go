@@ -1,14 +1,17 @@
 package upyun
 
 import (
+	"context"
+	"crypto/md5"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"os"
 	"path"
@@ -27,6 +30,7 @@ const (
 )
 
 type restReqConfig struct {
+	op        string
 	method    string
 	uri       string
 	query     string
@@ -34,6 +38,10 @@ type restReqConfig struct {
 	closeBody bool
 	httpBody  io.Reader
 	useMD5    bool
+	// maxTries overrides RetryPolicy.MaxAttempts if > 0
+	maxTries int
+	// endpoint, if set, is the only endpoint the request is sent to
+	endpoint string
 }
 
 // GetObjectConfig provides a configuration to Get method.
@@ -43,6 +51,77 @@ type GetObjectConfig struct {
 	Headers   map[string]string
 	LocalPath string
 	Writer    io.Writer
+	// Offset and Length select a part of the object, see ByteRange.
+	Offset int64
+	Length int64
+	// Ranges selects several parts of the object, which are written to
+	// Writer one after another. It can not be used with Offset or Length.
+	Ranges []ByteRange
+	// PreserveModTime sets the mtime of LocalPath to the Last-Modified of
+	// the object.
+	PreserveModTime bool
+	Conditions
+	// Progress, if set, receives the progress of the download, at most
+	// one ProgressTransferred event per ProgressInterval.
+	Progress         ProgressListener
+	ProgressInterval time.Duration
+}
+
+// HeadObjectConfig provides a configuration to Head method.
+type HeadObjectConfig struct {
+	Path string
+	// Headers contains custom http header, like User-Agent.
+	Headers map[string]string
+	Conditions
+}
+
+// Conditions are the validators of a conditional request. If the object
+// matches IfNoneMatch or has not been modified since IfModifiedSince, the
+// request succeeds with FileInfo.NotModified set and nothing is
+// downloaded. If the object does not match IfMatch, the request fails with
+// ErrPreconditionFailed.
+type Conditions struct {
+	// IfNoneMatch and IfMatch are ETags, e.g. FileInfo.MD5 of an earlier
+	// response, quotes are optional.
+	IfNoneMatch     string
+	IfMatch         string
+	IfModifiedSince time.Time
+}
+
+func (c *Conditions) setHeaders(headers map[string]string) {
+	if c.IfNoneMatch != "" {
+		headers["If-None-Match"] = quoteETag(c.IfNoneMatch)
+	}
+	if c.IfMatch != "" {
+		headers["If-Match"] = quoteETag(c.IfMatch)
+	}
+	if !c.IfModifiedSince.IsZero() {
+		headers["If-Modified-Since"] = c.IfModifiedSince.UTC().Format(http.TimeFormat)
+	}
+}
+
+func quoteETag(etag string) string {
+	if etag == "*" || strings.HasPrefix(etag, "\"") || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "\"" + etag + "\""
+}
+
+// notModified returns the FileInfo of a 304 response to the validators of
+// c, nil if err is not one. A 304 to validators set by Headers stays an
+// error.
+func (c *Conditions) notModified(err error, path string) *FileInfo {
+	if c.IfNoneMatch == "" && c.IfModifiedSince.IsZero() {
+		return nil
+	}
+	var ae *Error
+	if !errors.As(err, &ae) || ae.StatusCode != http.StatusNotModified {
+		return nil
+	}
+	fInfo := parseHeaderToFileInfo(ae.Header, false)
+	fInfo.Name = path
+	fInfo.NotModified = true
+	return fInfo
 }
 
 // GetObjectConfig provides a configuration to List method.
@@ -61,7 +140,6 @@ type GetObjectsConfig struct {
 	rootDir string
 	level   int
 	objNum  int
-	try     int
 }
 
 // ListObjectsConfig list objects Config
@@ -89,8 +167,19 @@ type PutObjectConfig struct {
 	UseResumeUpload bool
 	// Append Api Deprecated
 	// AppendContent     bool
-	ResumePartSize    int64
+	ResumePartSize int64
+	// MaxResumePutTries is the number of attempts of each part, 0 means no
+	// limit. The parts are not retried by RetryPolicy on top of it. Once a
+	// part runs out of tries, the breakpoint is saved and the last error is
+	// returned.
 	MaxResumePutTries int
+	// ResumeUploadID is the upload id ResumePut continues, it defaults to
+	// the latest one of the ResumeRecoder.
+	ResumeUploadID string
+	// Progress, if set, receives the progress of the upload, at most one
+	// ProgressTransferred event per ProgressInterval.
+	Progress         ProgressListener
+	ProgressInterval time.Duration
 }
 
 type MoveObjectConfig struct {
@@ -110,6 +199,10 @@ type UploadPartConfig struct {
 	Reader   io.Reader
 	PartSize int64
 	PartID   int
+	// Progress, if set, receives the progress of the part, at most one
+	// ProgressTransferred event per ProgressInterval.
+	Progress         ProgressListener
+	ProgressInterval time.Duration
 }
 type CompleteMultipartUploadConfig struct {
 	Md5 string
@@ -165,8 +258,13 @@ type ListUploadedPartsResult struct {
 }
 
 func (up *UpYun) Usage() (n int64, err error) {
+	return up.UsageWithContext(context.Background())
+}
+
+func (up *UpYun) UsageWithContext(ctx context.Context) (n int64, err error) {
 	var resp *http.Response
-	resp, err = up.doRESTRequest(&restReqConfig{
+	resp, err = up.doRESTRequest(ctx, &restReqConfig{
+		op:     "usage",
 		method: "GET",
 		uri:    "/",
 		query:  "usage",
@@ -183,7 +281,13 @@ func (up *UpYun) Usage() (n int64, err error) {
 }
 
 func (up *UpYun) Mkdir(path string) error {
-	_, err := up.doRESTRequest(&restReqConfig{
+	return up.MkdirWithContext(context.Background(), path)
+}
+
+func (up *UpYun) MkdirWithContext(ctx context.Context, path string) error {
+	op := fmt.Sprintf("mkdir %s", path)
+	_, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:     op,
 		method: "POST",
 		uri:    path,
 		headers: map[string]string{
@@ -193,51 +297,126 @@ func (up *UpYun) Mkdir(path string) error {
 		closeBody: true,
 	})
 	if err != nil {
-		return errorOperation(fmt.Sprintf("mkdir %s", path), err)
+		return errorOperation(op, err)
 	}
 	return nil
 }
 
 func (up *UpYun) Get(config *GetObjectConfig) (fInfo *FileInfo, err error) {
+	return up.GetWithContext(context.Background(), config)
+}
+
+func (up *UpYun) GetWithContext(ctx context.Context, config *GetObjectConfig) (*FileInfo, error) {
+	return up.getObject(ctx, config, 0)
+}
+
+// getObject is GetWithContext with maxTries overriding
+// RetryPolicy.MaxAttempts if > 0, for callers which retry on their own.
+func (up *UpYun) getObject(ctx context.Context, config *GetObjectConfig, maxTries int) (fInfo *FileInfo, err error) {
+	writer := config.Writer
+	var fd *atomicFile
 	if config.LocalPath != "" {
-		var fd *os.File
-		if fd, err = os.Create(config.LocalPath); err != nil {
+		// LocalPath is replaced only after the whole object is received
+		if fd, err = createAtomicFile(config.LocalPath); err != nil {
 			return nil, errorOperation("create file", err)
 		}
-		defer fd.Close()
-		config.Writer = fd
-	}
-
-	if config.Headers == nil {
-		config.Headers = make(map[string]string)
+		defer fd.abort()
+		writer = fd
 	}
 
-	config.Headers["x-upyun-folder"] = "false"
+	headers := copyHeaders(config.Headers)
+	headers["x-upyun-folder"] = "false"
+	config.Conditions.setHeaders(headers)
 
-	if config.Writer == nil {
+	if writer == nil {
 		return nil, errors.New("no writer")
 	}
 
-	resp, err := up.doRESTRequest(&restReqConfig{
-		method:  "GET",
-		uri:     config.Path,
-		headers: config.Headers,
+	ranges := config.Ranges
+	if config.Offset != 0 || config.Length != 0 {
+		if len(ranges) > 0 {
+			return nil, errors.New("Offset or Length can't be used with Ranges")
+		}
+		ranges = []ByteRange{{Offset: config.Offset, Length: config.Length}}
+	}
+	if err = validateRanges(ranges); err != nil {
+		return nil, err
+	}
+	if len(ranges) > 0 {
+		headers["Range"] = rangeHeader(ranges)
+	}
+
+	ctx, progress := startProgress(ctx, config.Progress, config.ProgressInterval, config.Path, -1)
+	defer func() { progress.finish(err) }()
+
+	op := fmt.Sprintf("get %s", config.Path)
+	resp, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:       op,
+		method:   "GET",
+		uri:      config.Path,
+		headers:  headers,
+		maxTries: maxTries,
 	})
 	if err != nil {
-		return nil, errorOperation(fmt.Sprintf("get %s", config.Path), err)
+		if fInfo = config.Conditions.notModified(err, config.Path); fInfo != nil {
+			return fInfo, nil
+		}
+		return nil, errorOperation(op, err)
 	}
 	defer resp.Body.Close()
+	progress.setTotal(resp.ContentLength)
+	resp.Body = progress.reader(resp.Body)
 
 	fInfo = parseHeaderToFileInfo(resp.Header, false)
 	fInfo.Name = config.Path
 
-	if fInfo.Size, err = io.Copy(config.Writer, resp.Body); err != nil {
+	if len(ranges) > 0 {
+		// Size is the size of the whole object rather than of the ranges,
+		// -1 if the server does not tell it
+		if fInfo.Size, err = copyRanges(writer, resp, ranges); err != nil {
+			return nil, errorOperation("get range", err)
+		}
+		if err = up.commitLocalFile(fd, config.PreserveModTime, fInfo); err != nil {
+			return nil, err
+		}
+		return fInfo, nil
+	}
+
+	var digest hash.Hash
+	if fd != nil {
+		digest = md5.New()
+		writer = io.MultiWriter(fd, digest)
+	}
+	if fInfo.Size, err = io.Copy(writer, resp.Body); err != nil {
 		return nil, errorOperation("io copy", err)
 	}
-	return
+	if digest != nil {
+		if err = verifyMD5(fInfo.MD5, hex.EncodeToString(digest.Sum(nil))); err != nil {
+			return nil, errorOperation(op, err)
+		}
+	}
+	if err = up.commitLocalFile(fd, config.PreserveModTime, fInfo); err != nil {
+		return nil, err
+	}
+	return fInfo, nil
+}
+
+// commitLocalFile moves the downloaded fd, if any, into place.
+func (up *UpYun) commitLocalFile(fd *atomicFile, preserveModTime bool, fInfo *FileInfo) error {
+	if fd == nil {
+		return nil
+	}
+	var modTime time.Time
+	if preserveModTime {
+		modTime = fInfo.Time
+	}
+	if err := fd.commit(modTime); err != nil {
+		return errorOperation("commit file", err)
+	}
+	return nil
 }
 
-func (up *UpYun) put(config *PutObjectConfig) error {
+func (up *UpYun) put(ctx context.Context, config *PutObjectConfig) error {
 	/* Append Api Deprecated
 	if config.AppendContent {
 		if config.Headers == nil {
@@ -246,7 +425,9 @@ func (up *UpYun) put(config *PutObjectConfig) error {
 		config.Headers["X-Upyun-Append"] = "true"
 	}
 	*/
-	_, err := up.doRESTRequest(&restReqConfig{
+	op := fmt.Sprintf("put %s", config.Path)
+	_, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:        op,
 		method:    "PUT",
 		uri:       config.Path,
 		headers:   config.Headers,
@@ -255,7 +436,7 @@ func (up *UpYun) put(config *PutObjectConfig) error {
 		useMD5:    config.UseMD5,
 	})
 	if err != nil {
-		return errorOperation(fmt.Sprintf("put %s", config.Path), err)
+		return errorOperation(op, err)
 	}
 	return nil
 }
@@ -279,6 +460,13 @@ func getPartInfo(partSize, fsize int64) (int64, int64, error) {
 }
 
 func (up *UpYun) Put(config *PutObjectConfig) (err error) {
+	return up.PutWithContext(context.Background(), config)
+}
+
+func (up *UpYun) PutWithContext(ctx context.Context, config *PutObjectConfig) (err error) {
+	// the caller's config may be shared by several goroutines
+	c := *config
+	config = &c
 	if config.LocalPath != "" {
 		var fd *os.File
 		if fd, err = os.Open(config.LocalPath); err != nil {
@@ -288,23 +476,33 @@ func (up *UpYun) Put(config *PutObjectConfig) (err error) {
 		config.Reader = fd
 	}
 
+	ctx, progress := startProgress(ctx, config.Progress, config.ProgressInterval,
+		config.Path, readerSize(config.Reader, config.Headers))
+	defer func() { progress.finish(err) }()
+
 	if config.UseResumeUpload {
-		return up.resumePut(config, nil)
+		return up.resumePut(ctx, config, up.recoder(), nil)
 	}
-	return up.put(config)
+	return up.put(ctx, config)
 }
 
 func (up *UpYun) Move(config *MoveObjectConfig) error {
+	return up.MoveWithContext(context.Background(), config)
+}
+
+func (up *UpYun) MoveWithContext(ctx context.Context, config *MoveObjectConfig) error {
 	headers := map[string]string{
 		"X-Upyun-Move-Source": path.Join("/", up.Bucket, escapeUri(config.SrcPath)),
 	}
 	for k, v := range config.Headers {
 		headers[k] = v
 	}
-	_, err := up.doRESTRequest(&restReqConfig{
-		method:  "PUT",
-		uri:     config.DestPath,
-		headers: headers,
+	_, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:        "move source",
+		method:    "PUT",
+		uri:       config.DestPath,
+		headers:   headers,
+		closeBody: true,
 	})
 	if err != nil {
 		return errorOperation("move source", err)
@@ -313,16 +511,22 @@ func (up *UpYun) Move(config *MoveObjectConfig) error {
 }
 
 func (up *UpYun) Copy(config *CopyObjectConfig) error {
+	return up.CopyWithContext(context.Background(), config)
+}
+
+func (up *UpYun) CopyWithContext(ctx context.Context, config *CopyObjectConfig) error {
 	headers := map[string]string{
 		"X-Upyun-Copy-Source": path.Join("/", up.Bucket, escapeUri(config.SrcPath)),
 	}
 	for k, v := range config.Headers {
 		headers[k] = v
 	}
-	_, err := up.doRESTRequest(&restReqConfig{
-		method:  "PUT",
-		uri:     config.DestPath,
-		headers: headers,
+	_, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:        "copy source",
+		method:    "PUT",
+		uri:       config.DestPath,
+		headers:   headers,
+		closeBody: true,
 	})
 	if err != nil {
 		return errorOperation("copy source", err)
@@ -331,6 +535,10 @@ func (up *UpYun) Copy(config *CopyObjectConfig) error {
 }
 
 func (up *UpYun) InitMultipartUpload(config *InitMultipartUploadConfig) (*InitMultipartUploadResult, error) {
+	return up.InitMultipartUploadWithContext(context.Background(), config)
+}
+
+func (up *UpYun) InitMultipartUploadWithContext(ctx context.Context, config *InitMultipartUploadConfig) (*InitMultipartUploadResult, error) {
 	partSize, _, err := getPartInfo(config.PartSize, config.ContentLength)
 	if err != nil {
 		return nil, errorOperation("init multipart", err)
@@ -345,7 +553,8 @@ func (up *UpYun) InitMultipartUpload(config *InitMultipartUploadConfig) (*InitMu
 		headers["X-Upyun-Multi-Disorder"] = "true"
 	}
 	headers["X-Upyun-Multi-Part-Size"] = strconv.FormatInt(partSize, 10)
-	resp, err := up.doRESTRequest(&restReqConfig{
+	resp, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:        "init multipart",
 		method:    "PUT",
 		uri:       config.Path,
 		headers:   headers,
@@ -361,19 +570,33 @@ func (up *UpYun) InitMultipartUpload(config *InitMultipartUploadConfig) (*InitMu
 	}, nil
 }
 func (up *UpYun) UploadPart(initResult *InitMultipartUploadResult, part *UploadPartConfig) error {
+	return up.UploadPartWithContext(context.Background(), initResult, part)
+}
+
+func (up *UpYun) UploadPartWithContext(ctx context.Context, initResult *InitMultipartUploadResult, part *UploadPartConfig) (err error) {
+	ctx, progress := startProgress(ctx, part.Progress, part.ProgressInterval, initResult.Path, part.PartSize)
+	defer func() { progress.finish(err) }()
+	return up.uploadPart(ctx, initResult, part, 0)
+}
+
+// uploadPart is UploadPart with maxTries overriding RetryPolicy.MaxAttempts
+// if > 0, for callers which retry parts on their own.
+func (up *UpYun) uploadPart(ctx context.Context, initResult *InitMultipartUploadResult, part *UploadPartConfig, maxTries int) error {
 	headers := make(map[string]string)
 	headers["X-Upyun-Multi-Stage"] = "upload"
 	headers["X-Upyun-Multi-Uuid"] = initResult.UploadID
 	headers["X-Upyun-Part-Id"] = strconv.FormatInt(int64(part.PartID), 10)
 	headers["Content-Length"] = strconv.FormatInt(part.PartSize, 10)
 
-	_, err := up.doRESTRequest(&restReqConfig{
+	_, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:        "upload multipart",
 		method:    "PUT",
 		uri:       initResult.Path,
 		headers:   headers,
 		closeBody: true,
 		useMD5:    false,
 		httpBody:  part.Reader,
+		maxTries:  maxTries,
 	})
 	if err != nil {
 		return errorOperation("upload multipart", err)
@@ -381,6 +604,11 @@ func (up *UpYun) UploadPart(initResult *InitMultipartUploadResult, part *UploadP
 	return nil
 }
 func (up *UpYun) CompleteMultipartUpload(initResult *InitMultipartUploadResult, config *CompleteMultipartUploadConfig) error {
+	return up.CompleteMultipartUploadWithContext(context.Background(), initResult, config)
+}
+
+func (up *UpYun) CompleteMultipartUploadWithContext(ctx context.Context, initResult *InitMultipartUploadResult,
+	config *CompleteMultipartUploadConfig) error {
 	headers := make(map[string]string)
 	headers["X-Upyun-Multi-Stage"] = "complete"
 	headers["X-Upyun-Multi-Uuid"] = initResult.UploadID
@@ -389,10 +617,12 @@ func (up *UpYun) CompleteMultipartUpload(initResult *InitMultipartUploadResult,
 			headers["X-Upyun-Multi-Md5"] = config.Md5
 		}
 	}
-	_, err := up.doRESTRequest(&restReqConfig{
-		method:  "PUT",
-		uri:     initResult.Path,
-		headers: headers,
+	_, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:        "complete multipart",
+		method:    "PUT",
+		uri:       initResult.Path,
+		headers:   headers,
+		closeBody: true,
 	})
 	if err != nil {
 		return errorOperation("complete multipart", err)
@@ -400,6 +630,10 @@ func (up *UpYun) CompleteMultipartUpload(initResult *InitMultipartUploadResult,
 	return nil
 }
 func (up *UpYun) ListMultipartUploads(config *ListMultipartConfig) (*ListMultipartUploadResult, error) {
+	return up.ListMultipartUploadsWithContext(context.Background(), config)
+}
+
+func (up *UpYun) ListMultipartUploadsWithContext(ctx context.Context, config *ListMultipartConfig) (*ListMultipartUploadResult, error) {
 	headers := make(map[string]string)
 	headers["X-Upyun-List-Type"] = "multi"
 	if config.Prefix != "" {
@@ -409,7 +643,8 @@ func (up *UpYun) ListMultipartUploads(config *ListMultipartConfig) (*ListMultipa
 		headers["X-Upyun-List-Limit"] = strconv.FormatInt(config.Limit, 10)
 	}
 
-	res, err := up.doRESTRequest(&restReqConfig{
+	res, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:        "list multipart",
 		method:    "GET",
 		headers:   headers,
 		uri:       "/",
@@ -421,6 +656,7 @@ func (up *UpYun) ListMultipartUploads(config *ListMultipartConfig) (*ListMultipa
 	}
 
 	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
 	if err != nil {
 		return nil, errorOperation("list multipart read body", err)
 	}
@@ -434,13 +670,19 @@ func (up *UpYun) ListMultipartUploads(config *ListMultipartConfig) (*ListMultipa
 }
 
 func (up *UpYun) ListMultipartParts(intiResult *InitMultipartUploadResult, config *ListMultipartPartsConfig) (*ListUploadedPartsResult, error) {
+	return up.ListMultipartPartsWithContext(context.Background(), intiResult, config)
+}
+
+func (up *UpYun) ListMultipartPartsWithContext(ctx context.Context, intiResult *InitMultipartUploadResult,
+	config *ListMultipartPartsConfig) (*ListUploadedPartsResult, error) {
 	headers := make(map[string]string)
 	headers["X-Upyun-Multi-Uuid"] = intiResult.UploadID
 
 	if config.BeginID > 0 {
 		headers["X-Upyun-Part-Id"] = fmt.Sprint(config.BeginID)
 	}
-	res, err := up.doRESTRequest(&restReqConfig{
+	res, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:        "list multipart parts",
 		method:    "GET",
 		headers:   headers,
 		uri:       intiResult.Path,
@@ -452,6 +694,7 @@ func (up *UpYun) ListMultipartParts(intiResult *InitMultipartUploadResult, confi
 	}
 
 	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
 	if err != nil {
 		return nil, errorOperation("list multipart parts read body", err)
 	}
@@ -464,6 +707,10 @@ func (up *UpYun) ListMultipartParts(intiResult *InitMultipartUploadResult, confi
 	return result, nil
 }
 func (up *UpYun) Delete(config *DeleteObjectConfig) error {
+	return up.DeleteWithContext(context.Background(), config)
+}
+
+func (up *UpYun) DeleteWithContext(ctx context.Context, config *DeleteObjectConfig) error {
 	headers := map[string]string{}
 	if config.Async {
 		headers["x-upyun-async"] = "true"
@@ -471,7 +718,8 @@ func (up *UpYun) Delete(config *DeleteObjectConfig) error {
 	if config.Folder {
 		headers["x-upyun-folder"] = "true"
 	}
-	_, err := up.doRESTRequest(&restReqConfig{
+	_, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:        "delete",
 		method:    "DELETE",
 		uri:       config.Path,
 		headers:   headers,
@@ -485,46 +733,87 @@ func (up *UpYun) Delete(config *DeleteObjectConfig) error {
 
 // GetRequest return response
 func (up *UpYun) GetRequest(config *GetRequestConfig) (*http.Response, error) {
+	return up.GetRequestWithContext(context.Background(), config)
+}
+
+func (up *UpYun) GetRequestWithContext(ctx context.Context, config *GetRequestConfig) (*http.Response, error) {
 	if config.Path == "" {
 		return nil, errors.New("needed set config.Path")
 	}
 
-	resp, err := up.doRESTRequest(&restReqConfig{
+	op := fmt.Sprintf("get %s", config.Path)
+	resp, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:      op,
 		method:  "GET",
 		uri:     config.Path,
 		headers: config.Headers,
 	})
 	if err != nil {
-		return nil, errorOperation(fmt.Sprintf("get %s", config.Path), err)
+		return nil, errorOperation(op, err)
 	}
 
 	return resp, nil
 }
 
 func (up *UpYun) GetInfo(path string) (*FileInfo, error) {
-	resp, err := up.doRESTRequest(&restReqConfig{
+	return up.GetInfoWithContext(context.Background(), path)
+}
+
+func (up *UpYun) GetInfoWithContext(ctx context.Context, path string) (*FileInfo, error) {
+	return up.HeadWithContext(ctx, &HeadObjectConfig{Path: path})
+}
+
+// Head is GetInfo with custom headers and conditions.
+func (up *UpYun) Head(config *HeadObjectConfig) (*FileInfo, error) {
+	return up.HeadWithContext(context.Background(), config)
+}
+
+func (up *UpYun) HeadWithContext(ctx context.Context, config *HeadObjectConfig) (*FileInfo, error) {
+	headers := copyHeaders(config.Headers)
+	config.Conditions.setHeaders(headers)
+	resp, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:        "get info",
 		method:    "HEAD",
-		uri:       path,
+		uri:       config.Path,
+		headers:   headers,
 		closeBody: true,
 	})
 	if err != nil {
+		if fInfo := config.Conditions.notModified(err, config.Path); fInfo != nil {
+			return fInfo, nil
+		}
 		return nil, errorOperation("get info", err)
 	}
 	fInfo := parseHeaderToFileInfo(resp.Header, true)
-	fInfo.Name = path
+	fInfo.Name = config.Path
 	return fInfo, nil
 }
 
 func (up *UpYun) List(config *GetObjectsConfig) error {
+	return up.ListWithContext(context.Background(), config)
+}
+
+// ListWithContext is like List, but stops walking the directory tree as soon
+// as ctx is done.
+func (up *UpYun) ListWithContext(ctx context.Context, config *GetObjectsConfig) error {
 	if config.ObjectsChan == nil {
 		return errors.New("ObjectsChan is nil")
 	}
+	c := *config
+	c.Headers = copyHeaders(config.Headers)
+	if c.QuitChan == nil {
+		c.QuitChan = make(chan bool)
+	}
+	return up.list(ctx, &c)
+}
+
+func (up *UpYun) list(ctx context.Context, config *GetObjectsConfig) (err error) {
+	ctx, span := up.startSpan(ctx, "list dir", "upyun.path", config.Path, "upyun.list_level", config.level)
+	defer func() { span.End(err) }()
+
 	if config.Headers == nil {
 		config.Headers = make(map[string]string)
 	}
-	if config.QuitChan == nil {
-		config.QuitChan = make(chan bool)
-	}
 	// 50 is nice value
 	if _, exist := config.Headers["X-List-Limit"]; !exist {
 		config.Headers["X-List-Limit"] = "50"
@@ -543,21 +832,18 @@ func (up *UpYun) List(config *GetObjectsConfig) error {
 	}
 
 	for {
-		resp, err := up.doRESTRequest(&restReqConfig{
-			method:  "GET",
-			uri:     config.Path,
-			headers: config.Headers,
-		})
+		if err := ctx.Err(); err != nil {
+			return errorOperation("list", err)
+		}
 
+		resp, err := up.doRESTRequest(ctx, &restReqConfig{
+			op:       "list",
+			method:   "GET",
+			uri:      config.Path,
+			headers:  config.Headers,
+			maxTries: config.MaxListTries,
+		})
 		if err != nil {
-			var nerr net.Error
-			if ok := errors.As(err, &nerr); ok {
-				config.try++
-				if config.MaxListTries == 0 || config.try < config.MaxListTries {
-					time.Sleep(10 * time.Millisecond)
-					continue
-				}
-			}
 			return errorOperation("list", err)
 		}
 
@@ -583,17 +869,16 @@ func (up *UpYun) List(config *GetObjectsConfig) error {
 					MaxListLevel:   config.MaxListLevel,
 					level:          config.level + 1,
 					rootDir:        path.Join(config.rootDir, fInfo.Name),
-					try:            config.try,
 					objNum:         config.objNum,
 				}
-				if err = up.List(rConfig); err != nil {
+				if err = up.list(ctx, rConfig); err != nil {
 					return err
 				}
 				// empty folder
 				if config.objNum == rConfig.objNum {
 					fInfo.IsEmptyDir = true
 				}
-				config.try, config.objNum = rConfig.try, rConfig.objNum
+				config.objNum = rConfig.objNum
 			}
 			if config.rootDir != "" {
 				fInfo.Name = path.Join(config.rootDir, fInfo.Name)
@@ -602,8 +887,14 @@ func (up *UpYun) List(config *GetObjectsConfig) error {
 			select {
 			case <-config.QuitChan:
 				return nil
+			case <-ctx.Done():
+				return errorOperation("list", ctx.Err())
 			default:
-				config.ObjectsChan <- fInfo
+				select {
+				case config.ObjectsChan <- fInfo:
+				case <-ctx.Done():
+					return errorOperation("list", ctx.Err())
+				}
 			}
 
 			config.objNum++
@@ -620,52 +911,42 @@ func (up *UpYun) List(config *GetObjectsConfig) error {
 }
 
 func (up *UpYun) ListObjects(config *ListObjectsConfig) (fileInfos []*FileInfo, iter string, err error) {
-	if config.Headers == nil {
-		config.Headers = make(map[string]string)
-	}
+	return up.ListObjectsWithContext(context.Background(), config)
+}
+
+func (up *UpYun) ListObjectsWithContext(ctx context.Context, config *ListObjectsConfig) (fileInfos []*FileInfo, iter string, err error) {
+	headers := copyHeaders(config.Headers)
 
 	if config.Limit == 0 || config.Limit > MaxLimit {
-		config.Headers["X-List-Limit"] = strconv.Itoa(DefaultLimit)
+		headers["X-List-Limit"] = strconv.Itoa(DefaultLimit)
 	} else {
-		config.Headers["X-List-Limit"] = strconv.Itoa(config.Limit)
+		headers["X-List-Limit"] = strconv.Itoa(config.Limit)
 	}
 
 	if config.DescOrder {
-		config.Headers["X-List-Order"] = "desc"
+		headers["X-List-Order"] = "desc"
 	}
 
 	if config.Iter != "" {
-		config.Headers["x-list-iter"] = config.Iter
+		headers["x-list-iter"] = config.Iter
 	}
 
-	if config.MaxListTries <= 0 {
-		config.MaxListTries = MaxListTries
+	maxListTries := config.MaxListTries
+	if maxListTries <= 0 {
+		maxListTries = MaxListTries
 	}
 
-	config.Headers["X-UpYun-Folder"] = "true"
-	config.Headers["Accept"] = "application/json"
-	var resp *http.Response
-	try := 0
-	for {
-		resp, err = up.doRESTRequest(&restReqConfig{
-			method:  "GET",
-			uri:     config.Path,
-			headers: config.Headers,
-		})
-
-		// 重试
-		if err != nil {
-			var nerr net.Error
-			if ok := errors.As(err, &nerr); ok {
-				try++
-				if try < config.MaxListTries {
-					time.Sleep(10 * time.Millisecond)
-					continue
-				}
-			}
-			return nil, "", errorOperation("list", err)
-		}
-		break
+	headers["X-UpYun-Folder"] = "true"
+	headers["Accept"] = "application/json"
+	resp, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:       "list",
+		method:   "GET",
+		uri:      config.Path,
+		headers:  headers,
+		maxTries: maxListTries, // 重试
+	})
+	if err != nil {
+		return nil, "", errorOperation("list", err)
 	}
 
 	// 读取列表
@@ -688,13 +969,19 @@ func (up *UpYun) ListObjects(config *ListObjectsConfig) (fileInfos []*FileInfo,
 }
 
 func (up *UpYun) ModifyMetadata(config *ModifyMetadataConfig) error {
-	if config.Operation == "" {
-		config.Operation = "merge"
+	return up.ModifyMetadataWithContext(context.Background(), config)
+}
+
+func (up *UpYun) ModifyMetadataWithContext(ctx context.Context, config *ModifyMetadataConfig) error {
+	operation := config.Operation
+	if operation == "" {
+		operation = "merge"
 	}
-	_, err := up.doRESTRequest(&restReqConfig{
+	_, err := up.doRESTRequest(ctx, &restReqConfig{
+		op:        "modify metadata",
 		method:    "PATCH",
 		uri:       config.Path,
-		query:     "metadata=" + config.Operation,
+		query:     "metadata=" + operation,
 		headers:   config.Headers,
 		closeBody: true,
 	})
@@ -704,7 +991,18 @@ func (up *UpYun) ModifyMetadata(config *ModifyMetadataConfig) error {
 	return nil
 }
 
-func (up *UpYun) doRESTRequest(config *restReqConfig) (*http.Response, error) {
+func (up *UpYun) doRESTRequest(ctx context.Context, config *restReqConfig) (resp *http.Response, err error) {
+	ctx, span := up.startSpan(ctx, opName(config.op),
+		"http.method", config.method,
+		"upyun.path", config.uri,
+	)
+	defer func() {
+		if resp != nil {
+			span.SetAttributes("http.status_code", resp.StatusCode)
+		}
+		span.End(err)
+	}()
+
 	escUri := path.Join("/", up.Bucket, escapeUri(config.uri))
 	if strings.HasSuffix(config.uri, "/") {
 		escUri += "/"
@@ -722,9 +1020,6 @@ func (up *UpYun) doRESTRequest(config *restReqConfig) (*http.Response, error) {
 		headers[k] = v
 	}
 
-	headers["Date"] = makeRFC1123Date(time.Now())
-	headers["Host"] = "v0.api.upyun.com"
-
 	if !hasMD5 && config.useMD5 {
 		switch v := config.httpBody.(type) {
 		case *os.File:
@@ -734,7 +1029,8 @@ func (up *UpYun) doRESTRequest(config *restReqConfig) (*http.Response, error) {
 		}
 	}
 
-	if up.deprecated {
+	deprecated := up.isDeprecated()
+	if deprecated {
 		if _, ok := headers["Content-Length"]; !ok {
 			size := int64(0)
 			switch v := config.httpBody.(type) {
@@ -747,25 +1043,37 @@ func (up *UpYun) doRESTRequest(config *restReqConfig) (*http.Response, error) {
 			}
 			headers["Content-Length"] = fmt.Sprint(size)
 		}
-		headers["Authorization"] = up.MakeRESTAuth(&RESTAuthConfig{
-			Method:    config.method,
-			Uri:       escUri,
-			DateStr:   headers["Date"],
-			LengthStr: headers["Content-Length"],
-		})
-	} else {
-		headers["Authorization"] = up.MakeUnifiedAuth(&UnifiedAuthConfig{
-			Method:     config.method,
-			Uri:        escUri,
-			DateStr:    headers["Date"],
-			ContentMD5: headers["Content-MD5"],
-		})
 	}
 
-	endpoint := up.doGetEndpoint("v0.api.upyun.com")
-	url := fmt.Sprintf("http://%s%s", endpoint, escUri)
+	sign := func(headers map[string]string) {
+		if deprecated {
+			headers["Authorization"] = up.MakeRESTAuth(&RESTAuthConfig{
+				Method:    config.method,
+				Uri:       escUri,
+				DateStr:   headers["Date"],
+				LengthStr: headers["Content-Length"],
+			})
+		} else {
+			headers["Authorization"] = up.MakeUnifiedAuth(&UnifiedAuthConfig{
+				Method:     config.method,
+				Uri:        escUri,
+				DateStr:    headers["Date"],
+				ContentMD5: headers["Content-MD5"],
+			})
+		}
+	}
 
-	resp, err := up.doHTTPRequest(config.method, url, headers, config.httpBody)
+	resp, err = up.doHTTPRequest(ctx, &httpReqConfig{
+		op:       config.op,
+		method:   config.method,
+		host:     "v0.api.upyun.com",
+		uri:      escUri,
+		headers:  headers,
+		sign:     sign,
+		body:     config.httpBody,
+		maxTries: config.maxTries,
+		endpoint: config.endpoint,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -788,6 +1096,16 @@ type BreakPointConfig struct {
 }
 
 func (up *UpYun) ResumePut(config *PutObjectConfig) (err error) {
+	return up.ResumePutWithContext(context.Background(), config)
+}
+
+func (up *UpYun) ResumePutWithContext(ctx context.Context, config *PutObjectConfig) (err error) {
+	recoder := up.recoder()
+	if recoder == nil {
+		return errors.New("resume put: no recoder, see SetBreakPoint")
+	}
+	c := *config
+	config = &c
 	if config.LocalPath != "" {
 		var fd *os.File
 		if fd, err = os.Open(config.LocalPath); err != nil {
@@ -796,42 +1114,57 @@ func (up *UpYun) ResumePut(config *PutObjectConfig) (err error) {
 		defer fd.Close()
 		config.Reader = fd
 	}
-	breakPoint, err := up.Recoder.Get(up.Recoder.UploadID)
+	uploadID := config.ResumeUploadID
+	if uploadID == "" {
+		uploadID = recoder.LastUploadID()
+	}
+	breakPoint, err := recoder.Get(uploadID)
 	if err != nil {
 		return err
 	}
-	return up.resumePut(config, breakPoint)
+
+	ctx, progress := startProgress(ctx, config.Progress, config.ProgressInterval,
+		config.Path, readerSize(config.Reader, config.Headers))
+	defer func() { progress.finish(err) }()
+	return up.resumePut(ctx, config, recoder, breakPoint)
 }
 
-func (up *UpYun) resumePut(config *PutObjectConfig, breakpoint *BreakPointConfig) error {
+// resumePut keeps the breakpoints in recoder, if it is not nil.
+func (up *UpYun) resumePut(ctx context.Context, config *PutObjectConfig, recoder *ResumeRecoder,
+	breakpoint *BreakPointConfig) (err error) {
+	ctx, span := up.startSpan(ctx, "resume put", "upyun.path", config.Path, "upyun.resume", breakpoint != nil)
+	defer func() { span.End(err) }()
+
+	var fileinfo fs.FileInfo
 	f, ok := config.Reader.(*os.File)
-	if !ok {
-		return errors.New("resumePut: type != *os.File")
+	if ok {
+		if fileinfo, err = f.Stat(); err != nil {
+			return errorOperation("stat", err)
+		}
 	}
-
-	fileinfo, err := f.Stat()
-	if err != nil {
-		return errorOperation("stat", err)
+	// pipes, e.g. os.Stdin, have no size either
+	if !ok || !fileinfo.Mode().IsRegular() {
+		if breakpoint != nil {
+			return errors.New("resumePut: only a regular file can be resumed")
+		}
+		return up.streamPut(ctx, config)
 	}
 
 	fsize := fileinfo.Size()
 	if fsize < minResumePutFileSize {
-		return up.put(config)
+		return up.put(ctx, config)
 	}
 
 	if config.ResumePartSize == 0 {
 		config.ResumePartSize = DefaultPartSize
 	}
 
-	if config.Headers == nil {
-		config.Headers = make(map[string]string)
-	}
-	headers := config.Headers
+	headers := copyHeaders(config.Headers)
 
 	// first upload
 	var uploadInfo *InitMultipartUploadResult
 	if breakpoint == nil {
-		uploadInfo, err = up.InitMultipartUpload(&InitMultipartUploadConfig{
+		uploadInfo, err = up.InitMultipartUploadWithContext(ctx, &InitMultipartUploadConfig{
 			Path:          config.Path,
 			PartSize:      config.ResumePartSize,
 			ContentType:   headers["Content-Type"],
@@ -848,10 +1181,11 @@ func (up *UpYun) resumePut(config *PutObjectConfig, breakpoint *BreakPointConfig
 			PartSize:  uploadInfo.PartSize,
 			PartID:    0,
 			MaxPartID: maxPartID,
+			UseMD5:    config.UseMD5,
 		}
 	}
 
-	err = up.resumeUploadPart(config, breakpoint, f, fileinfo)
+	err = up.resumeUploadPart(ctx, config, recoder, breakpoint, f, fileinfo)
 	if err != nil {
 		return err
 	}
@@ -862,7 +1196,7 @@ func (up *UpYun) resumePut(config *PutObjectConfig, breakpoint *BreakPointConfig
 		completeConfig.Md5, _ = md5File(f)
 	}
 
-	return up.CompleteMultipartUpload(
+	return up.CompleteMultipartUploadWithContext(ctx,
 		&InitMultipartUploadResult{
 			UploadID: breakpoint.UploadID,
 			Path:     config.Path,
@@ -870,38 +1204,59 @@ func (up *UpYun) resumePut(config *PutObjectConfig, breakpoint *BreakPointConfig
 		}, completeConfig)
 }
 
-func (up *UpYun) resumeUploadPart(config *PutObjectConfig, breakpoint *BreakPointConfig, f *os.File, fileInfo fs.FileInfo) error {
-	up.Recoder.UploadID = breakpoint.UploadID
+func (up *UpYun) resumeUploadPart(ctx context.Context, config *PutObjectConfig, recoder *ResumeRecoder,
+	breakpoint *BreakPointConfig, f *os.File, fileInfo fs.FileInfo) error {
+	if recoder != nil {
+		recoder.setUploadID(breakpoint.UploadID)
+	}
 	fsize := int64(breakpoint.MaxPartID+1) * breakpoint.PartSize
 	maxPartID := breakpoint.MaxPartID
 	partID := breakpoint.PartID
 	curSize, partSize := int64(partID)*breakpoint.PartSize, breakpoint.PartSize
 
-	if breakpoint.UseMD5 {
-		if breakpoint.ContentMd5 != "" {
-			// 判断之前上传的文件是否发生了修改
-			partFile, err := newFragmentFile(f, int64(partID+1)*partSize, partSize)
-			if err != nil {
-				return errorOperation("new fragment file", err)
+	if breakpoint.UseMD5 && breakpoint.ContentMd5 != "" {
+		// 判断之前上传的文件是否发生了修改
+		fmd5, err := partMD5(f, breakpoint, partID, fileInfo.Size())
+		if err != nil {
+			return errorOperation("md5 part", err)
+		}
+		if fmd5 != breakpoint.ContentMd5 {
+			return &Error{
+				Code:      ErrCodeMD5Mismatch,
+				Message:   fmt.Sprintf("part %d of the file has changed since it was interrupted", partID),
+				Operation: "resume put",
 			}
-			fmd5, err := fileBufMd5(partFile)
+		}
+	}
+
+	// saveAt records that the upload stopped at part id
+	saveAt := func(id int) error {
+		breakpoint.PartID = id
+		if breakpoint.UseMD5 {
+			fmd5, err := partMD5(f, breakpoint, id, fileInfo.Size())
 			if err != nil {
-				return err
-			}
-			if fmd5 != breakpoint.ContentMd5 {
-				return err
+				return errorOperation("md5 part", err)
 			}
+			breakpoint.ContentMd5 = fmd5
 		}
+		return saveBreakPoint(recoder, breakpoint)
 	}
 
 	if isFileExpired(fileInfo, fsize) {
 		return errors.New("resume file has expired")
 	}
 
+	progress := progressFromContext(ctx)
+	progress.skip(curSize)
+
 	for id := partID; id <= maxPartID; id++ {
 		if curSize+partSize > fsize {
 			partSize = fsize - curSize
 		}
+		// fsize is rounded up to whole parts, the last one may be shorter
+		if curSize+partSize > fileInfo.Size() {
+			partSize = fileInfo.Size() - curSize
+		}
 
 		fragFile, err := newFragmentFile(f, curSize, partSize)
 		if err != nil {
@@ -910,7 +1265,22 @@ func (up *UpYun) resumeUploadPart(config *PutObjectConfig, breakpoint *BreakPoin
 
 		try := 0
 		for ; config.MaxResumePutTries == 0 || try < config.MaxResumePutTries; try++ {
-			err = up.UploadPart(
+			if try > 0 {
+				progress.retry(id, try, err)
+				// ctx is checked below
+				_ = sleepWithContext(ctx, up.retryPolicy().backoff(try, err))
+				if _, err = fragFile.Seek(0, io.SeekStart); err != nil {
+					return errorOperation("new fragment file", err)
+				}
+			}
+			if ctx.Err() != nil {
+				// keep what has been uploaded so far, so that ResumePut
+				// can continue from this part later.
+				saveAt(id)
+				return errorOperation("upload multipart", ctx.Err())
+			}
+			// the parts are retried here, MaxResumePutTries times
+			err = up.uploadPart(ctx,
 				&InitMultipartUploadResult{
 					UploadID: breakpoint.UploadID,
 					Path:     config.Path,
@@ -920,29 +1290,46 @@ func (up *UpYun) resumeUploadPart(config *PutObjectConfig, breakpoint *BreakPoin
 					PartID:   id,
 					PartSize: partSize,
 					Reader:   fragFile,
-				})
+				}, 1)
 			if err == nil {
 				break
 			}
+			if !IsRetryable(err) {
+				saveAt(id)
+				return err
+			}
 		}
 
 		if config.MaxResumePutTries > 0 && try == config.MaxResumePutTries {
-			partFile, err := newFragmentFile(f, int64(partID+1)*partSize, partSize)
-			if err != nil {
-				return errorOperation("new fragment file", err)
-			}
-			fmd5, err := fileBufMd5(partFile)
-			if err != nil {
-				return err
+			if serr := saveAt(id); serr != nil {
+				return serr
 			}
-
-			breakpoint.PartID = id
-			breakpoint.ContentMd5 = fmd5
-
-			return up.Recoder.Set(breakpoint)
+			return errorOperation("upload multipart", err)
 		}
+		progress.partCompleted(id)
 		curSize += partSize
 	}
 
 	return nil
 }
+
+// partMD5 returns the md5 of part id of f, whose size is size.
+func partMD5(f *os.File, breakpoint *BreakPointConfig, id int, size int64) (string, error) {
+	offset := int64(id) * breakpoint.PartSize
+	n := breakpoint.PartSize
+	if offset+n > size {
+		n = size - offset
+	}
+	partFile, err := newFragmentFile(f, offset, n)
+	if err != nil {
+		return "", err
+	}
+	return md5File(partFile)
+}
+
+func saveBreakPoint(recoder *ResumeRecoder, breakpoint *BreakPointConfig) error {
+	if recoder == nil {
+		return nil
+	}
+	return recoder.Set(breakpoint)
+}
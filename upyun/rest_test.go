@@ -2,13 +2,26 @@ package upyun
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -26,18 +39,21 @@ var (
 )
 
 func TestPrintEndpoint(t *testing.T) {
+	requireLive(t)
 	c, err := net.Dial("tcp", "v0.api.upyun.com:80")
 	Nil(t, err)
 	fmt.Printf("v0.api: %s, client_ip: %s\n", c.RemoteAddr(), c.LocalAddr())
 }
 
 func TestUsage(t *testing.T) {
+	requireLive(t)
 	n, err := up.Usage()
 	Nil(t, err)
 	Equal(t, n > 0, true)
 }
 
 func TestGetInfoDir(t *testing.T) {
+	requireLive(t)
 	fInfo, err := up.GetInfo("/")
 	Nil(t, err)
 	NotNil(t, fInfo)
@@ -45,11 +61,13 @@ func TestGetInfoDir(t *testing.T) {
 }
 
 func TestMkdir(t *testing.T) {
+	requireLive(t)
 	err := up.Mkdir(REST_DIR)
 	Nil(t, err)
 }
 
 func TestPutWithFileReader(t *testing.T) {
+	requireLive(t)
 	fd, _ := os.Open(LOCAL_FILE)
 	NotNil(t, fd)
 	defer fd.Close()
@@ -66,6 +84,7 @@ func TestPutWithFileReader(t *testing.T) {
 }
 
 func TestPutWithBuffer(t *testing.T) {
+	requireLive(t)
 	s := BUF_CONTENT
 	r := strings.NewReader(s)
 
@@ -81,6 +100,7 @@ func TestPutWithBuffer(t *testing.T) {
 }
 
 func TestCopyMove(t *testing.T) {
+	requireLive(t)
 	s := BUF_CONTENT
 	r := strings.NewReader(s)
 
@@ -169,6 +189,7 @@ func testMultiUpload(t *testing.T, key string, data []byte, partSize int64, part
 	return uploadResult
 }
 func TestMultiListParts(t *testing.T) {
+	requireLive(t)
 	data10m := make([]byte, 10*1024*1024)
 	partSize := int64(3 * 1024 * 1024)
 	prefixKey := TempKey(t)
@@ -184,6 +205,7 @@ func TestMultiListParts(t *testing.T) {
 	Equal(t, len(result.Parts), 1)
 }
 func TestMultiGetUpload(t *testing.T) {
+	requireLive(t)
 	data10m := make([]byte, 10*1024*1024)
 	partSize := int64(3 * 1024 * 1024)
 	prefixKey := TempKey(t)
@@ -207,6 +229,7 @@ func TestMultiGetUpload(t *testing.T) {
 	Equal(t, len(result.Files), len(keyMap))
 }
 func TestResumePut(t *testing.T) {
+	requireLive(t)
 	fname := "1M"
 	fd, _ := os.Create(fname)
 	NotNil(t, fd)
@@ -228,6 +251,7 @@ func TestResumePut(t *testing.T) {
 }
 
 func TestGetWithWriter(t *testing.T) {
+	requireLive(t)
 	b := make([]byte, 0)
 	buf := bytes.NewBuffer(b)
 	fInfo, err := up.Get(&GetObjectConfig{
@@ -242,6 +266,7 @@ func TestGetWithWriter(t *testing.T) {
 }
 
 func TestGetWithLocalPath(t *testing.T) {
+	requireLive(t)
 	defer os.Remove(LOCAL_SAVE_FILE)
 	fInfo, err := up.Get(&GetObjectConfig{
 		Path:      REST_FILE_1,
@@ -267,7 +292,52 @@ func TestGetWithLocalPath(t *testing.T) {
 	Equal(t, string(b1), string(b2))
 }
 
+func TestGetLocalPathMode(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	bucket.put("/a", []byte("a"))
+	dir := t.TempDir()
+
+	// a new file gets the mode of os.Create
+	ref, err := os.Create(filepath.Join(dir, "ref"))
+	Nil(t, err)
+	ref.Close()
+	refInfo, err := os.Stat(ref.Name())
+	Nil(t, err)
+	fname := filepath.Join(dir, "new")
+	_, err = fake.Get(&GetObjectConfig{Path: "/a", LocalPath: fname})
+	Nil(t, err)
+	stat, err := os.Stat(fname)
+	Nil(t, err)
+	Equal(t, stat.Mode().Perm(), refInfo.Mode().Perm())
+
+	// an existing file keeps its mode
+	fname = filepath.Join(dir, "existing")
+	Nil(t, ioutil.WriteFile(fname, []byte("old"), 0600))
+	Nil(t, os.Chmod(fname, 0600))
+	_, err = fake.Get(&GetObjectConfig{Path: "/a", LocalPath: fname, Offset: 0, Length: 1})
+	Nil(t, err)
+	stat, err = os.Stat(fname)
+	Nil(t, err)
+	Equal(t, stat.Mode().Perm(), os.FileMode(0600))
+	b, err := ioutil.ReadFile(fname)
+	Nil(t, err)
+	Equal(t, string(b), "a")
+
+	// a directory in the way can not be replaced
+	fname = filepath.Join(dir, "dir")
+	Nil(t, os.MkdirAll(filepath.Join(fname, "sub"), 0755))
+	for _, config := range []*GetObjectConfig{
+		{Path: "/a", LocalPath: fname},
+		{Path: "/a", LocalPath: fname, Length: 1},
+	} {
+		fInfo, err := fake.Get(config)
+		NotNil(t, err)
+		Equal(t, fInfo == nil, true)
+	}
+}
+
 func TestGetInfoFile(t *testing.T) {
+	requireLive(t)
 	fInfo, err := up.GetInfo(REST_FILE_BUF)
 	Nil(t, err)
 	NotNil(t, fInfo)
@@ -279,6 +349,7 @@ func TestGetInfoFile(t *testing.T) {
 }
 
 func TestList(t *testing.T) {
+	requireLive(t)
 	ch := make(chan *FileInfo, 10)
 	files := []string{}
 
@@ -303,11 +374,13 @@ func TestList(t *testing.T) {
 }
 
 func TestIsNotExist(t *testing.T) {
+	requireLive(t)
 	_, err := up.GetInfo("/NotExist")
 	Equal(t, IsNotExist(err), true)
 }
 
 func TestModifyMetadata(t *testing.T) {
+	requireLive(t)
 	//	time.Sleep(10 * time.Second)
 	err := up.ModifyMetadata(&ModifyMetadataConfig{
 		Path:      REST_FILE_1,
@@ -321,6 +394,7 @@ func TestModifyMetadata(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
+	requireLive(t)
 	time.Sleep(time.Second)
 	err := up.Delete(&DeleteObjectConfig{
 		Path: REST_DIR,
@@ -379,6 +453,7 @@ func putTestFilesToBucket(t *testing.T, remotePath string) []FileInfo {
 }
 
 func TestListObjects(t *testing.T) {
+	requireLive(t)
 	remotePath := "/go-sdk/lb/"
 	limit := 1
 
@@ -418,6 +493,7 @@ func TestListObjects(t *testing.T) {
 }
 
 func TestResumePutV2(t *testing.T) {
+	requireLive(t)
 	fname := "50M"
 	fd, _ := os.Create(fname)
 	NotNil(t, fd)
@@ -438,3 +514,646 @@ func TestResumePutV2(t *testing.T) {
 	})
 	Nil(t, err)
 }
+
+func TestListWithContextCanceled(t *testing.T) {
+	var requests int32
+	bucket := newFakeBucket()
+	bucket.put("/a", []byte("a"))
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		bucket.ServeHTTP(w, r)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	objs := make(chan *FileInfo, 10)
+	err := fake.ListWithContext(ctx, &GetObjectsConfig{
+		Path:        "/",
+		ObjectsChan: objs,
+	})
+	NotNil(t, err)
+	Equal(t, errors.Is(err, context.Canceled), true)
+
+	_, err = fake.GetWithContext(ctx, &GetObjectConfig{
+		Path:   "/a",
+		Writer: ioutil.Discard,
+	})
+	Equal(t, errors.Is(err, context.Canceled), true)
+	Equal(t, atomic.LoadInt32(&requests), int32(0))
+}
+
+// cancelInFlight returns an UpYun whose requests matching block hang until
+// the client gives up or the test ends, the returned channel is closed on
+// the first of them.
+func cancelInFlight(t *testing.T, block func(r *http.Request) bool) (*UpYun, *fakeBucket, <-chan struct{}) {
+	bucket := newFakeBucket()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		if !block(r) {
+			bucket.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == "GET" {
+			w.Header().Set("Content-Length", "1024")
+			w.WriteHeader(http.StatusOK)
+			w.Write(make([]byte, 512))
+			w.(http.Flusher).Flush()
+		}
+		once.Do(func() { close(started) })
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	// runs before the server is closed
+	t.Cleanup(func() { close(release) })
+	return fake, bucket, started
+}
+
+func TestPutCanceledMidRequest(t *testing.T) {
+	fake, bucket, started := cancelInFlight(t, func(r *http.Request) bool {
+		return r.Method == "PUT"
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	err := fake.PutWithContext(ctx, &PutObjectConfig{
+		Path:   "/a",
+		Reader: strings.NewReader("content"),
+	})
+	Equal(t, errors.Is(err, context.Canceled), true)
+	Equal(t, bucket.get("/a") == nil, true)
+}
+
+func TestGetCanceledMidRequest(t *testing.T) {
+	fake, _, started := cancelInFlight(t, func(r *http.Request) bool {
+		return r.Method == "GET"
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	var buf bytes.Buffer
+	_, err := fake.GetWithContext(ctx, &GetObjectConfig{
+		Path:   "/a",
+		Writer: &buf,
+	})
+	Equal(t, errors.Is(err, context.Canceled), true)
+	Equal(t, buf.Len() < 1024, true)
+}
+
+func TestResumePutCanceledMidRequest(t *testing.T) {
+	fake, bucket, started := cancelInFlight(t, func(r *http.Request) bool {
+		return r.Header.Get("X-Upyun-Multi-Stage") == "upload" &&
+			r.Header.Get("X-Upyun-Part-Id") == "1"
+	})
+	recoder := &ResumeRecoder{}
+	fake.SetBreakPoint(recoder)
+
+	content := bytes.Repeat([]byte("K"), minResumePutFileSize+DefaultPartSize/2)
+	fname := filepath.Join(t.TempDir(), "big")
+	Nil(t, ioutil.WriteFile(fname, content, 0644))
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	err := fake.PutWithContext(ctx, &PutObjectConfig{
+		Path:            "/big",
+		LocalPath:       fname,
+		UseResumeUpload: true,
+	})
+	Equal(t, errors.Is(err, context.Canceled), true)
+	Equal(t, bucket.get("/big") == nil, true)
+
+	breakpoint, err := recoder.Get(recoder.LastUploadID())
+	Nil(t, err)
+	Equal(t, breakpoint.PartID, 1)
+}
+
+func TestHTTPSWithCustomCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, r.TLS != nil, true)
+		w.Write([]byte("1024"))
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	fake := NewUpYun(&UpYunConfig{
+		Bucket:    "bucket",
+		Operator:  "operator",
+		Password:  "password",
+		Hosts:     map[string]string{"v0.api.upyun.com": srv.Listener.Addr().String()},
+		Scheme:    "https",
+		TLSConfig: &tls.Config{RootCAs: pool},
+	})
+
+	n, err := fake.Usage()
+	Nil(t, err)
+	Equal(t, n, int64(1024))
+
+	// an unknown CA is not retried
+	untrusted := NewUpYun(&UpYunConfig{
+		Bucket: "bucket",
+		Hosts:  map[string]string{"v0.api.upyun.com": srv.Listener.Addr().String()},
+		Scheme: "https",
+	})
+	_, err = untrusted.Usage()
+	NotNil(t, err)
+	Equal(t, IsRetryable(err), false)
+}
+
+func TestDefaultScheme(t *testing.T) {
+	Equal(t, NewUpYun(&UpYunConfig{Bucket: "bucket"}).Scheme, "https")
+	// custom hosts keep talking http unless told otherwise
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1024"))
+	}))
+	defer srv.Close()
+	up := NewUpYun(&UpYunConfig{
+		Bucket: "bucket",
+		Hosts:  map[string]string{"v0.api.upyun.com": srv.Listener.Addr().String()},
+	})
+	Equal(t, up.Scheme, "http")
+	n, err := up.Usage()
+	Nil(t, err)
+	Equal(t, n, int64(1024))
+}
+
+func TestConcurrentUploadsAndListings(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	recoder := &ResumeRecoder{}
+	fake.SetBreakPoint(recoder)
+
+	big := bytes.Repeat([]byte("U"), minResumePutFileSize+DefaultPartSize/2)
+	fname := filepath.Join(t.TempDir(), "big")
+	Nil(t, ioutil.WriteFile(fname, big, 0644))
+	small := filepath.Join(t.TempDir(), "small")
+	Nil(t, ioutil.WriteFile(small, []byte("small"), 0644))
+
+	// shared by all goroutines, none of them may modify it
+	headers := map[string]string{"X-Upyun-Meta-Shared": "1"}
+	putConfig := &PutObjectConfig{LocalPath: fname, UseResumeUpload: true, Headers: headers}
+	getConfig := &GetObjectConfig{Path: "/small-0", Headers: headers}
+	listConfig := &ListObjectsConfig{Path: "/", Headers: headers, Limit: 2}
+	formConfig := &FormUploadConfig{LocalPath: small, Options: map[string]interface{}{"x": "y"}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := *putConfig
+			c.Path = fmt.Sprintf("/big-%d", i)
+			Nil(t, fake.Put(&c))
+		}(i)
+	}
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			Nil(t, fake.Put(&PutObjectConfig{
+				Path:    fmt.Sprintf("/small-%d", i%4),
+				Reader:  strings.NewReader("small"),
+				Headers: headers,
+			}))
+
+			var buf bytes.Buffer
+			gc := *getConfig
+			gc.Writer = &buf
+			if _, err := fake.Get(&gc); err == nil {
+				Equal(t, buf.String(), "small")
+			}
+
+			_, _, err := fake.ListObjects(listConfig)
+			Nil(t, err)
+
+			objs := make(chan *FileInfo, 8)
+			go func() {
+				for range objs {
+				}
+			}()
+			Nil(t, fake.List(&GetObjectsConfig{Path: "/", ObjectsChan: objs, Headers: headers}))
+
+			fc := *formConfig
+			fc.SaveKey = fmt.Sprintf("/form-%d", i)
+			_, err = fake.FormUpload(&fc)
+			Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	Equal(t, headers, map[string]string{"X-Upyun-Meta-Shared": "1"})
+	Equal(t, listConfig.MaxListTries, 0)
+	Equal(t, formConfig.Options, map[string]interface{}{"x": "y"})
+	Equal(t, putConfig.Reader, nil)
+	for i := 0; i < 2; i++ {
+		Equal(t, len(bucket.get(fmt.Sprintf("/big-%d", i))), len(big))
+	}
+	NotEqual(t, recoder.LastUploadID(), "")
+}
+
+func TestResumeRecoderConcurrent(t *testing.T) {
+	recoder := &ResumeRecoder{}
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("concurrent-%d", i)
+			Nil(t, recoder.Set(&BreakPointConfig{UploadID: id, PartID: i}))
+			recoder.setUploadID(id)
+			bp, err := recoder.Get(id)
+			Nil(t, err)
+			Equal(t, bp.PartID, i)
+			recoder.LastUploadID()
+			Nil(t, recoder.Delete(id))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestResumePutWithoutRecoder(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	big := bytes.Repeat([]byte("U"), minResumePutFileSize+1)
+	fname := filepath.Join(t.TempDir(), "big")
+	Nil(t, ioutil.WriteFile(fname, big, 0644))
+
+	Nil(t, fake.Put(&PutObjectConfig{Path: "/big", LocalPath: fname, UseResumeUpload: true}))
+	Equal(t, len(bucket.get("/big")), len(big))
+	NotNil(t, fake.ResumePut(&PutObjectConfig{Path: "/big", LocalPath: fname}))
+}
+
+func TestConditionalGet(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	bucket.put("/conditional", []byte("hello world"))
+
+	var buf bytes.Buffer
+	fInfo, err := fake.Get(&GetObjectConfig{Path: "/conditional", Writer: &buf})
+	Nil(t, err)
+	Equal(t, fInfo.NotModified, false)
+	etag := fInfo.MD5
+
+	buf.Reset()
+	fname := filepath.Join(t.TempDir(), "conditional")
+	for _, config := range []*GetObjectConfig{
+		{Path: "/conditional", Writer: &buf, Conditions: Conditions{IfNoneMatch: etag}},
+		{Path: "/conditional", Writer: &buf, Conditions: Conditions{IfNoneMatch: "\"" + etag + "\""}},
+		{Path: "/conditional", Writer: &buf, Conditions: Conditions{IfModifiedSince: fInfo.Time}},
+		{Path: "/conditional", LocalPath: fname, Conditions: Conditions{IfNoneMatch: etag}},
+	} {
+		fInfo, err := fake.Get(config)
+		Nil(t, err)
+		Equal(t, fInfo.NotModified, true)
+		Equal(t, fInfo.MD5, etag)
+		Equal(t, buf.Len(), 0)
+	}
+	_, err = os.Stat(fname)
+	Equal(t, os.IsNotExist(err), true)
+
+	bucket.put("/conditional", []byte("hello again"))
+	fInfo, err = fake.Get(&GetObjectConfig{Path: "/conditional", Writer: &buf, Conditions: Conditions{IfNoneMatch: etag}})
+	Nil(t, err)
+	Equal(t, fInfo.NotModified, false)
+	Equal(t, buf.String(), "hello again")
+
+	_, err = fake.Get(&GetObjectConfig{Path: "/conditional", Writer: &buf, Conditions: Conditions{IfMatch: etag}})
+	Equal(t, errors.Is(err, ErrPreconditionFailed), true)
+
+	// validators of Headers keep failing with 304 as before
+	fInfo, err = fake.Get(&GetObjectConfig{Path: "/conditional", Writer: &buf,
+		Headers: map[string]string{"If-None-Match": fmt.Sprintf("\"%x\"", md5.Sum([]byte("hello again")))}})
+	Equal(t, IsNotModified(err), true)
+	Equal(t, fInfo == nil, true)
+}
+
+func TestConditionalHead(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	bucket.put("/conditional", []byte("hello world"))
+
+	fInfo, err := fake.Head(&HeadObjectConfig{Path: "/conditional"})
+	Nil(t, err)
+	Equal(t, fInfo.NotModified, false)
+	Equal(t, fInfo.Size, int64(11))
+
+	fInfo, err = fake.Head(&HeadObjectConfig{Path: "/conditional", Conditions: Conditions{IfNoneMatch: fInfo.MD5}})
+	Nil(t, err)
+	Equal(t, fInfo.NotModified, true)
+	Equal(t, fInfo.Name, "/conditional")
+
+	fInfo, err = fake.Head(&HeadObjectConfig{Path: "/conditional", Conditions: Conditions{IfModifiedSince: time.Now().Add(-time.Hour)}})
+	Nil(t, err)
+	Equal(t, fInfo.NotModified, false)
+
+	_, err = fake.Head(&HeadObjectConfig{Path: "/conditional", Conditions: Conditions{IfMatch: "0123"}})
+	Equal(t, errors.Is(err, ErrPreconditionFailed), true)
+
+	fInfo, err = fake.Head(&HeadObjectConfig{Path: "/conditional",
+		Headers: map[string]string{"If-None-Match": fmt.Sprintf("\"%x\"", md5.Sum([]byte("hello world")))}})
+	Equal(t, IsNotModified(err), true)
+	Equal(t, fInfo == nil, true)
+}
+
+func TestStreamPut(t *testing.T) {
+	bucket := newFakeBucket()
+	var mu sync.Mutex
+	stages := map[string]int{}
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		stage := r.Header.Get("X-Upyun-Multi-Stage")
+		mu.Lock()
+		stages[stage]++
+		first := stage == "upload" && r.Header.Get("X-Upyun-Part-Id") == "1" && stages["retried"] == 0
+		if first {
+			stages["retried"]++
+		}
+		mu.Unlock()
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bucket.ServeHTTP(w, r)
+	})
+
+	content := make([]byte, DefaultPartSize*5/2)
+	rand.Read(content)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(content)
+		pw.Close()
+	}()
+	var rec progressRecorder
+	Nil(t, fake.Put(&PutObjectConfig{
+		Path:            "/stream",
+		Reader:          pr,
+		UseResumeUpload: true,
+		Progress:        rec.listen,
+	}))
+	Equal(t, bytes.Equal(bucket.get("/stream"), content), true)
+	Equal(t, stages["initiate"], 1)
+	Equal(t, stages["upload"], 4)
+	Equal(t, stages["complete"], 1)
+	rec.check(t, ProgressCompleted, int64(len(content)), -1)
+	Equal(t, rec.count(ProgressPartCompleted), 3)
+
+	// a short stream is put at once
+	Nil(t, fake.Put(&PutObjectConfig{
+		Path:            "/short",
+		Reader:          io.MultiReader(strings.NewReader("short")),
+		UseResumeUpload: true,
+		UseMD5:          true,
+	}))
+	Equal(t, string(bucket.get("/short")), "short")
+	Equal(t, stages["initiate"], 1)
+
+	// a stream of exactly one part
+	content = content[:DefaultPartSize]
+	Nil(t, fake.Put(&PutObjectConfig{
+		Path:            "/one",
+		Reader:          io.MultiReader(bytes.NewReader(content)),
+		UseResumeUpload: true,
+	}))
+	Equal(t, bytes.Equal(bucket.get("/one"), content), true)
+
+	fake.SetBreakPoint(&ResumeRecoder{})
+	fake.Recoder.Set(&BreakPointConfig{UploadID: "upload-1", PartSize: DefaultPartSize})
+	NotNil(t, fake.ResumePut(&PutObjectConfig{
+		Path:           "/stream",
+		Reader:         strings.NewReader("x"),
+		ResumeUploadID: "upload-1",
+	}))
+}
+
+func TestStreamPutPipe(t *testing.T) {
+	bucket := newFakeBucket()
+	var mu sync.Mutex
+	stages := map[string]int{}
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		stages[r.Header.Get("X-Upyun-Multi-Stage")]++
+		mu.Unlock()
+		bucket.ServeHTTP(w, r)
+	})
+
+	// an *os.File which is not a regular file, like os.Stdin
+	content := make([]byte, DefaultPartSize*3)
+	rand.Read(content)
+	pr, pw, err := os.Pipe()
+	Nil(t, err)
+	defer pr.Close()
+	go func() {
+		pw.Write(content)
+		pw.Close()
+	}()
+	Nil(t, fake.Put(&PutObjectConfig{
+		Path:            "/pipe",
+		Reader:          pr,
+		UseResumeUpload: true,
+	}))
+	Equal(t, bytes.Equal(bucket.get("/pipe"), content), true)
+	Equal(t, stages["initiate"], 1)
+	Equal(t, stages["upload"], 3)
+	Equal(t, stages["complete"], 1)
+}
+
+func TestStreamPutTries(t *testing.T) {
+	bucket := newFakeBucket()
+	var mu sync.Mutex
+	stages := map[string]int{}
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		stage := r.Header.Get("X-Upyun-Multi-Stage")
+		mu.Lock()
+		stages[stage]++
+		mu.Unlock()
+		if stage == "upload" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bucket.ServeHTTP(w, r)
+	})
+
+	content := bytes.Repeat([]byte("s"), DefaultPartSize*2)
+	// a stream can not be resumed, so its parts are not retried forever
+	err := fake.Put(&PutObjectConfig{
+		Path:            "/stream",
+		Reader:          io.MultiReader(bytes.NewReader(content)),
+		UseResumeUpload: true,
+	})
+	Equal(t, errors.Is(err, ErrServer), true)
+	Equal(t, stages["upload"], fake.retryPolicy().MaxAttempts)
+	Equal(t, stages["complete"], 0)
+
+	stages = map[string]int{}
+	err = fake.Put(&PutObjectConfig{
+		Path:              "/stream",
+		Reader:            io.MultiReader(bytes.NewReader(content)),
+		UseResumeUpload:   true,
+		MaxResumePutTries: 5,
+	})
+	Equal(t, errors.Is(err, ErrServer), true)
+	Equal(t, stages["upload"], 5)
+}
+
+func TestSettersWhileRequesting(t *testing.T) {
+	fake, bucket := NewFakeBucketUpYun(t)
+	bucket.put("/a", []byte("a"))
+	httpc := &http.Client{}
+	fname := filepath.Join(t.TempDir(), "big")
+	Nil(t, ioutil.WriteFile(fname, bytes.Repeat([]byte("S"), minResumePutFileSize+1), 0644))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// resumable uploads use the recoder while it is replaced
+		for j := 0; j < 2; j++ {
+			Nil(t, fake.Put(&PutObjectConfig{Path: "/big", LocalPath: fname, UseResumeUpload: true}))
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := fake.GetInfo("/a")
+				Nil(t, err)
+			}
+		}()
+	}
+	for j := 0; j < 10; j++ {
+		fake.SetLogger(NewLogger(ioutil.Discard, LogLevelDebug))
+		fake.SetMetricsCollector(nil)
+		fake.SetTracer(nil)
+		fake.SetHTTPClient(httpc)
+		fake.SetBreakPoint(nil)
+		fake.SetBreakPoint(&ResumeRecoder{})
+	}
+	wg.Wait()
+}
+
+func TestResumePutLastPart(t *testing.T) {
+	bucket := newFakeBucket()
+	var mu sync.Mutex
+	sizes := map[string]int64{}
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Upyun-Multi-Stage") == "upload" {
+			mu.Lock()
+			sizes[r.Header.Get("X-Upyun-Part-Id")] = r.ContentLength
+			mu.Unlock()
+		}
+		bucket.ServeHTTP(w, r)
+	})
+
+	// the file ends in the middle of the last part
+	content := bytes.Repeat([]byte("L"), minResumePutFileSize+DefaultPartSize/2)
+	fname := filepath.Join(t.TempDir(), "big")
+	Nil(t, ioutil.WriteFile(fname, content, 0644))
+	Nil(t, fake.Put(&PutObjectConfig{
+		Path:              "/big",
+		LocalPath:         fname,
+		UseResumeUpload:   true,
+		MaxResumePutTries: 2,
+	}))
+	parts := (len(content) + DefaultPartSize - 1) / DefaultPartSize
+	Equal(t, len(sizes), parts)
+	Equal(t, sizes["0"], int64(DefaultPartSize))
+	Equal(t, sizes[strconv.Itoa(parts-1)], int64(DefaultPartSize/2))
+	Equal(t, bytes.Equal(bucket.get("/big"), content), true)
+}
+
+func TestResumePutChangedFile(t *testing.T) {
+	bucket := newFakeBucket()
+	var mu sync.Mutex
+	stages := map[string]int{}
+	failing := true
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		stage := r.Header.Get("X-Upyun-Multi-Stage")
+		mu.Lock()
+		stages[stage]++
+		fail := failing && stage == "upload" && r.Header.Get("X-Upyun-Part-Id") == "1"
+		mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bucket.ServeHTTP(w, r)
+	})
+	recoder := &ResumeRecoder{}
+	fake.SetBreakPoint(recoder)
+
+	content := bytes.Repeat([]byte("C"), minResumePutFileSize+DefaultPartSize/2)
+	fname := filepath.Join(t.TempDir(), "big")
+	Nil(t, ioutil.WriteFile(fname, content, 0644))
+	config := &PutObjectConfig{
+		Path:              "/big",
+		LocalPath:         fname,
+		UseResumeUpload:   true,
+		UseMD5:            true,
+		MaxResumePutTries: 1,
+	}
+	NotNil(t, fake.Put(config))
+	breakpoint, err := recoder.Get(recoder.LastUploadID())
+	Nil(t, err)
+	Equal(t, breakpoint.UseMD5, true)
+	Equal(t, breakpoint.PartID, 1)
+
+	// the part to resume from has changed
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	changed := append([]byte(nil), content...)
+	changed[DefaultPartSize+1] = 'X'
+	Nil(t, ioutil.WriteFile(fname, changed, 0644))
+	err = fake.ResumePut(config)
+	Equal(t, errors.Is(err, ErrMD5Mismatch), true)
+	Equal(t, HasCode(err, ErrCodeMD5Mismatch), true)
+	Equal(t, stages["complete"], 0)
+
+	Nil(t, ioutil.WriteFile(fname, content, 0644))
+	Nil(t, fake.ResumePut(config))
+	Equal(t, bytes.Equal(bucket.get("/big"), content), true)
+}
+
+func TestResumePutPartFailure(t *testing.T) {
+	bucket := newFakeBucket()
+	var mu sync.Mutex
+	stages := map[string]int{}
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		stage := r.Header.Get("X-Upyun-Multi-Stage")
+		mu.Lock()
+		stages[stage]++
+		mu.Unlock()
+		if stage == "upload" && r.Header.Get("X-Upyun-Part-Id") != "0" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bucket.ServeHTTP(w, r)
+	})
+	recoder := &ResumeRecoder{}
+	fake.SetBreakPoint(recoder)
+
+	content := bytes.Repeat([]byte("F"), minResumePutFileSize+DefaultPartSize/2)
+	fname := filepath.Join(t.TempDir(), "big")
+	Nil(t, ioutil.WriteFile(fname, content, 0644))
+	err := fake.Put(&PutObjectConfig{
+		Path:              "/big",
+		LocalPath:         fname,
+		UseResumeUpload:   true,
+		MaxResumePutTries: 2,
+	})
+	NotNil(t, err)
+	Equal(t, errors.Is(err, ErrServer), true)
+	// part 0 once, part 1 MaxResumePutTries times
+	Equal(t, stages["upload"], 3)
+	Equal(t, stages["complete"], 0)
+	Equal(t, bucket.get("/big") == nil, true)
+
+	breakpoint, err := recoder.Get(recoder.LastUploadID())
+	Nil(t, err)
+	Equal(t, breakpoint.PartID, 1)
+}
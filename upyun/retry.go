@@ -0,0 +1,129 @@
+package upyun
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how a failed request is retried.
+//
+// A request is retried on network errors, on 429 Too Many Requests and on
+// 5xx server errors. POST requests are not idempotent, so they are only
+// retried on 429, which means the server has not handled them at all.
+// Requests whose body can not be rewound (does not implement io.Seeker)
+// are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	// A value <= 1 disables retrying.
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry, it doubles on each
+	// following retry.
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts.
+	MaxBackoff time.Duration
+	// Jitter is the fraction (0 ~ 1) of the delay which is randomized.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is used when UpYunConfig.RetryPolicy is nil.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  100 * time.Millisecond,
+	MaxBackoff:  5 * time.Second,
+	Jitter:      0.2,
+}
+
+func (up *UpYun) retryPolicy() *RetryPolicy {
+	if up.RetryPolicy != nil {
+		return up.RetryPolicy
+	}
+	return DefaultRetryPolicy
+}
+
+// backoff returns the delay before the next attempt, try is the number of
+// attempts already made.
+func (p *RetryPolicy) backoff(try int, err error) time.Duration {
+	if d, ok := retryAfter(err); ok {
+		return d
+	}
+
+	d := p.MinBackoff
+	for i := 1; i < try && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 && d > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// retryAfter parses the Retry-After header of the response which err
+// comes from, both delay-seconds and HTTP-date are supported.
+func retryAfter(err error) (time.Duration, bool) {
+	var ae *Error
+	if !errors.As(err, &ae) || ae.Header == nil {
+		return 0, false
+	}
+	v := ae.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if sec, perr := strconv.Atoi(v); perr == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second, true
+	}
+	if t, perr := http.ParseTime(v); perr == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+func shouldRetry(method string, err error) bool {
+	// the server has not handled a throttled request at all
+	if IsTooManyRequests(err) {
+		return true
+	}
+	return method != "POST" && IsRetryable(err)
+}
+
+// bodyRewinder returns a function which resets body to its current offset,
+// or nil if body can not be rewound.
+func bodyRewinder(body io.Reader) func() error {
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return nil
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	return func() error {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+}
+
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package upyun
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryRewindsBody(t *testing.T) {
+	var tries int32
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		Equal(t, string(b), BUF_CONTENT)
+		if atomic.AddInt32(&tries, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	err := fake.Put(&PutObjectConfig{
+		Path:   "/retry",
+		Reader: strings.NewReader(BUF_CONTENT),
+	})
+	Nil(t, err)
+	Equal(t, atomic.LoadInt32(&tries), int32(3))
+}
+
+func TestRetryNonRewindableBody(t *testing.T) {
+	var tries int32
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tries, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	err := fake.Put(&PutObjectConfig{
+		Path:   "/retry",
+		Reader: io.MultiReader(strings.NewReader(BUF_CONTENT)),
+	})
+	NotNil(t, err)
+	Equal(t, atomic.LoadInt32(&tries), int32(1))
+}
+
+func TestRetryAfter(t *testing.T) {
+	var tries int32
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&tries, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+
+	start := time.Now()
+	err := fake.Mkdir("/retry")
+	Nil(t, err)
+	Equal(t, atomic.LoadInt32(&tries), int32(2))
+	Equal(t, time.Since(start) >= time.Second, true)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	Equal(t, p.backoff(1, nil), 100*time.Millisecond)
+	Equal(t, p.backoff(3, nil), 400*time.Millisecond)
+	Equal(t, p.backoff(10, nil), time.Second)
+}
@@ -0,0 +1,98 @@
+package upyun
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+)
+
+// streamPut uploads config.Reader of unknown length part by part, each part
+// is buffered in memory so that it can be sent again. A reader which ends
+// within the first part is uploaded by a single put.
+func (up *UpYun) streamPut(ctx context.Context, config *PutObjectConfig) (err error) {
+	ctx, span := up.startSpan(ctx, "stream put", "upyun.path", config.Path)
+	defer func() { span.End(err) }()
+
+	partSize, _, err := getPartInfo(config.ResumePartSize, 0)
+	if err != nil {
+		return errorOperation("stream put", err)
+	}
+
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(config.Reader, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		c := *config
+		c.Headers = copyHeaders(config.Headers)
+		c.Reader = bytes.NewReader(buf[:n])
+		if config.UseMD5 {
+			c.Headers["Content-MD5"] = md5Str(string(buf[:n]))
+		}
+		return up.put(ctx, &c)
+	}
+	if err != nil {
+		return errorOperation("stream put read", err)
+	}
+
+	headers := copyHeaders(config.Headers)
+	uploadInfo, err := up.InitMultipartUploadWithContext(ctx, &InitMultipartUploadConfig{
+		Path:        config.Path,
+		PartSize:    partSize,
+		ContentType: headers["Content-Type"],
+		OrderUpload: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	progress := progressFromContext(ctx)
+	digest := md5.New()
+	for id := 0; n > 0; id++ {
+		digest.Write(buf[:n])
+		if err = up.uploadStreamPart(ctx, config, uploadInfo, id, buf[:n]); err != nil {
+			return err
+		}
+		progress.partCompleted(id)
+
+		n, err = io.ReadFull(config.Reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return errorOperation("stream put read", err)
+		}
+	}
+
+	return up.CompleteMultipartUploadWithContext(ctx, uploadInfo, &CompleteMultipartUploadConfig{
+		Md5: hex.EncodeToString(digest.Sum(nil)),
+	})
+}
+
+// uploadStreamPart uploads a part, MaxResumePutTries times at most. A stream
+// can not be resumed later, so it is never retried without limit:
+// RetryPolicy.MaxAttempts applies if MaxResumePutTries is 0.
+func (up *UpYun) uploadStreamPart(ctx context.Context, config *PutObjectConfig,
+	uploadInfo *InitMultipartUploadResult, id int, part []byte) (err error) {
+	maxTries := config.MaxResumePutTries
+	if maxTries <= 0 {
+		maxTries = up.retryPolicy().MaxAttempts
+	}
+	for try := 0; try < maxTries; try++ {
+		if try > 0 {
+			progressFromContext(ctx).retry(id, try, err)
+			if serr := sleepWithContext(ctx, up.retryPolicy().backoff(try, err)); serr != nil {
+				return errorOperation("upload multipart", serr)
+			}
+		}
+		err = up.uploadPart(ctx, uploadInfo, &UploadPartConfig{
+			PartID:   id,
+			PartSize: int64(len(part)),
+			Reader:   bytes.NewReader(part),
+		}, 1)
+		if err == nil || !IsRetryable(err) {
+			break
+		}
+	}
+	if err != nil {
+		return errorOperation("upload multipart", err)
+	}
+	return nil
+}
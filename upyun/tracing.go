@@ -0,0 +1,106 @@
+package upyun
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SpanContext identifies a span, see https://www.w3.org/TR/trace-context/
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether both TraceID and SpanID are non-zero.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent formats sc as a W3C traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceParent parses a W3C traceparent header value.
+func ParseTraceParent(s string) (sc SpanContext, err error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if _, err = hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("invalid traceparent %q: %w", s, err)
+	}
+	if _, err = hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("invalid traceparent %q: %w", s, err)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("invalid traceparent %q: %w", s, err)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
+
+// Span is a single operation within a trace.
+type Span interface {
+	SpanContext() SpanContext
+	// SetAttributes sets alternating keys and values on the span.
+	SetAttributes(keyvals ...interface{})
+	// End finishes the span, err is the result of the operation.
+	End(err error)
+}
+
+// Tracer starts spans for SDK operations. A new span must be a child of
+// SpanFromContext(ctx) if there is one.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) Span
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SpanContext() SpanContext     { return SpanContext{} }
+func (nopSpan) SetAttributes(...interface{}) {}
+func (nopSpan) End(error)                    {}
+
+// SetTracer sets the tracer which receives the spans of the SDK operations.
+// The traceparent header of every request is set from the current span.
+func (up *UpYun) SetTracer(tracer Tracer) {
+	up.settingsMu.Lock()
+	defer up.settingsMu.Unlock()
+	up.tracer = tracer
+}
+
+func (up *UpYun) startSpan(ctx context.Context, name string, keyvals ...interface{}) (context.Context, Span) {
+	up.settingsMu.RLock()
+	tracer := up.tracer
+	up.settingsMu.RUnlock()
+	if tracer == nil {
+		return ctx, nopSpan{}
+	}
+	span := tracer.StartSpan(ctx, name)
+	span.SetAttributes(append([]interface{}{"upyun.bucket", up.Bucket}, keyvals...)...)
+	return ContextWithSpan(ctx, span), span
+}
@@ -0,0 +1,75 @@
+package upyun
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+type testSpan struct {
+	name   string
+	sc     SpanContext
+	parent SpanContext
+	attrs  map[string]interface{}
+	ended  bool
+}
+
+func (s *testSpan) SpanContext() SpanContext { return s.sc }
+func (s *testSpan) SetAttributes(keyvals ...interface{}) {
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		s.attrs[keyvals[i].(string)] = keyvals[i+1]
+	}
+}
+func (s *testSpan) End(err error) { s.ended = true }
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (tr *testTracer) StartSpan(ctx context.Context, name string) Span {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	span := &testSpan{name: name, attrs: map[string]interface{}{}}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.parent = parent.SpanContext()
+		span.sc.TraceID = span.parent.TraceID
+	} else {
+		span.sc.TraceID[0] = byte(len(tr.spans) + 1)
+	}
+	binary.BigEndian.PutUint64(span.sc.SpanID[:], uint64(len(tr.spans)+1))
+	span.sc.Sampled = true
+	tr.spans = append(tr.spans, span)
+	return span
+}
+
+func TestTracing(t *testing.T) {
+	var traceparent string
+	fake := NewFakeUpYun(t, func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`{"files":[],"iter":"g2gCZAAEbmV4dGQAA2VvZg"}`))
+	})
+	tracer := &testTracer{}
+	fake.SetTracer(tracer)
+
+	err := fake.List(&GetObjectsConfig{
+		Path:        "/",
+		ObjectsChan: make(chan *FileInfo, 1),
+	})
+	Nil(t, err)
+
+	Equal(t, len(tracer.spans), 2)
+	dir, req := tracer.spans[0], tracer.spans[1]
+	Equal(t, dir.name, "list dir")
+	Equal(t, req.name, "list")
+	Equal(t, req.parent, dir.sc)
+	Equal(t, req.attrs["http.status_code"], http.StatusOK)
+	Equal(t, dir.ended && req.ended, true)
+	Equal(t, traceparent, req.sc.TraceParent())
+
+	sc, err := ParseTraceParent(traceparent)
+	Nil(t, err)
+	Equal(t, sc, req.sc)
+}
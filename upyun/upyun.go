@@ -1,8 +1,11 @@
 package upyun
 
 import (
+	"crypto/tls"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -11,6 +14,7 @@ const (
 
 	defaultChunkSize      = 32 * 1024
 	defaultConnectTimeout = time.Second * 60
+	defaultScheme         = "https"
 )
 
 type UpYunConfig struct {
@@ -20,22 +24,100 @@ type UpYunConfig struct {
 	Secret    string // deprecated
 	Hosts     map[string]string
 	UserAgent string
+	// RetryPolicy defaults to DefaultRetryPolicy if nil
+	RetryPolicy *RetryPolicy
+	// Scheme used to talk to all UpYun endpoints: "https" or "http". It
+	// defaults to "https", or to "http" if Hosts is set, because the hosts
+	// may well be IPs or proxies which do not serve https.
+	Scheme string
+	// TLSConfig is used by the default http client for https endpoints,
+	// e.g. set TLSConfig.RootCAs to trust a custom CA.
+	TLSConfig *tls.Config
+	// PasswordMD5 is the md5 hex digest of Password, it can be used instead
+	// of Password so that the plaintext never lives in config.
+	PasswordMD5 string
+	// CredentialsProvider takes precedence over Operator and Password if
+	// set, it is consulted every time a request is signed.
+	CredentialsProvider CredentialsProvider
+	// Endpoints maps a logical host (v0.api.upyun.com, p0.api.upyun.com,
+	// p1.api.upyun.com or purge.upyun.com) to an ordered list of endpoints.
+	// Requests go to the first healthy one, idempotent requests fail over to
+	// the next one on connection failures. It takes precedence over Hosts.
+	Endpoints map[string][]string
+	// CircuitBreaker defaults to DefaultCircuitBreaker if nil
+	CircuitBreaker *CircuitBreaker
+	// Timeout limits each attempt of a request, including reading the
+	// response body, 0 means no limit. Prefer a context deadline for
+	// downloading large files.
+	Timeout time.Duration
+	// ConnectTimeout defaults to 60 seconds
+	ConnectTimeout time.Duration
+	// RateLimits throttles requests and bandwidth, it can be changed by
+	// SetRateLimits at runtime.
+	RateLimits *RateLimits
 }
 
 type UpYun struct {
+	// accessed atomically, keep it first to be 64-bit aligned
+	clockSkew int64
+
 	UpYunConfig
+
+	// settingsMu guards the settings below, which may be changed while
+	// requests are in flight
+	settingsMu sync.RWMutex
+	// Recoder is set by SetBreakPoint, it must not be assigned directly
+	// while requests are in flight.
+	Recoder    *ResumeRecoder
 	httpc      *http.Client
 	deprecated bool
-	Recoder    *ResumeRecoder
+	logger     Logger
+	metrics    MetricsCollector
+	tracer     Tracer
+
+	endpointMu     sync.RWMutex
+	endpointGroups map[string]*endpointGroup
+
+	limitsMu sync.RWMutex
+
+	mwMu        sync.RWMutex
+	middlewares []Middleware
 }
 
 func NewUpYun(config *UpYunConfig) *UpYun {
 	up := &UpYun{}
 	up.Bucket = config.Bucket
 	up.Operator = config.Operator
-	up.Password = md5Str(config.Password)
+	up.Password = config.PasswordMD5
+	if up.Password == "" {
+		up.Password = md5Str(config.Password)
+	}
+	up.CredentialsProvider = config.CredentialsProvider
 	up.Secret = config.Secret
 	up.Hosts = config.Hosts
+	up.RetryPolicy = config.RetryPolicy
+	up.CircuitBreaker = config.CircuitBreaker
+	up.Endpoints = config.Endpoints
+	up.endpointGroups = make(map[string]*endpointGroup)
+	for host, addrs := range config.Endpoints {
+		if len(addrs) > 0 {
+			up.endpointGroups[host] = newEndpointGroup(addrs, up.circuitBreaker())
+		}
+	}
+	up.TLSConfig = config.TLSConfig
+	up.Timeout = config.Timeout
+	up.RateLimits = config.RateLimits
+	up.ConnectTimeout = config.ConnectTimeout
+	if up.ConnectTimeout <= 0 {
+		up.ConnectTimeout = defaultConnectTimeout
+	}
+	up.Scheme = strings.ToLower(config.Scheme)
+	if up.Scheme == "" {
+		up.Scheme = defaultScheme
+		if len(config.Hosts) > 0 {
+			up.Scheme = "http"
+		}
+	}
 	if config.UserAgent != "" {
 		up.UserAgent = config.UserAgent
 	} else {
@@ -45,22 +127,48 @@ func NewUpYun(config *UpYunConfig) *UpYun {
 	up.httpc = &http.Client{
 		Transport: &http.Transport{
 			Dial: func(network, addr string) (c net.Conn, err error) {
-				return net.DialTimeout(network, addr, defaultConnectTimeout)
+				return net.DialTimeout(network, addr, up.ConnectTimeout)
 			},
+			TLSClientConfig: config.TLSConfig,
 		},
+		Timeout: config.Timeout,
 	}
 
 	return up
 }
 
 func (up *UpYun) SetHTTPClient(httpc *http.Client) {
+	up.settingsMu.Lock()
+	defer up.settingsMu.Unlock()
 	up.httpc = httpc
 }
 
+func (up *UpYun) httpClient() *http.Client {
+	up.settingsMu.RLock()
+	defer up.settingsMu.RUnlock()
+	return up.httpc
+}
+
 func (up *UpYun) UseDeprecatedApi() {
+	up.settingsMu.Lock()
+	defer up.settingsMu.Unlock()
 	up.deprecated = true
 }
 
+func (up *UpYun) isDeprecated() bool {
+	up.settingsMu.RLock()
+	defer up.settingsMu.RUnlock()
+	return up.deprecated
+}
+
 func (up *UpYun) SetBreakPoint(recoder *ResumeRecoder) {
+	up.settingsMu.Lock()
+	defer up.settingsMu.Unlock()
 	up.Recoder = recoder
 }
+
+func (up *UpYun) recoder() *ResumeRecoder {
+	up.settingsMu.RLock()
+	defer up.settingsMu.RUnlock()
+	return up.Recoder
+}
@@ -1,13 +1,22 @@
 package upyun
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -99,7 +108,237 @@ func isNil(object interface{}) bool {
 
 }
 
+// NewFakeUpYun returns an UpYun which talks to a local server backed by h
+// instead of the UpYun API.
+func NewFakeUpYun(t *testing.T, h http.HandlerFunc) *UpYun {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	addr := srv.Listener.Addr().String()
+	return NewUpYun(&UpYunConfig{
+		Bucket:   "bucket",
+		Operator: "operator",
+		Password: "password",
+		Scheme:   "http",
+		Hosts: map[string]string{
+			"v0.api.upyun.com": addr,
+			"p0.api.upyun.com": addr,
+			"p1.api.upyun.com": addr,
+		},
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts: 3,
+			MinBackoff:  time.Millisecond,
+			MaxBackoff:  10 * time.Millisecond,
+		},
+	})
+}
+
+// fakeBucket is an in-memory UpYun bucket for offline tests, it supports
+// put, multipart upload, form upload, get with ranges, head, list and delete.
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	uploads map[string]map[int][]byte
+	nextID  int
+}
+
+type fakeObject struct {
+	data    []byte
+	isDir   bool
+	modTime time.Time
+}
+
+func newFakeBucket() *fakeBucket {
+	return &fakeBucket{
+		objects: make(map[string]*fakeObject),
+		uploads: make(map[string]map[int][]byte),
+	}
+}
+
+// NewFakeBucketUpYun returns an UpYun talking to a fakeBucket.
+func NewFakeBucketUpYun(t *testing.T) (*UpYun, *fakeBucket) {
+	b := newFakeBucket()
+	return NewFakeUpYun(t, b.ServeHTTP), b
+}
+
+func (b *fakeBucket) put(name string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[name] = &fakeObject{data: data, modTime: time.Now().Truncate(time.Second)}
+}
+
+func (b *fakeBucket) get(name string) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if o := b.objects[name]; o != nil {
+		return o.data
+	}
+	return nil
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/bucket")
+	if name == "" {
+		name = "/"
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch r.Method {
+	case "PUT":
+		switch r.Header.Get("X-Upyun-Multi-Stage") {
+		case "initiate":
+			b.nextID++
+			id := fmt.Sprintf("upload-%d", b.nextID)
+			b.uploads[id] = make(map[int][]byte)
+			w.Header().Set("X-Upyun-Multi-Uuid", id)
+		case "upload":
+			parts := b.uploads[r.Header.Get("X-Upyun-Multi-Uuid")]
+			if parts == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			data, _ := ioutil.ReadAll(r.Body)
+			id, _ := strconv.Atoi(r.Header.Get("X-Upyun-Part-Id"))
+			parts[id] = data
+		case "complete":
+			uploadID := r.Header.Get("X-Upyun-Multi-Uuid")
+			parts := b.uploads[uploadID]
+			var data []byte
+			for i := 0; i < len(parts); i++ {
+				data = append(data, parts[i]...)
+			}
+			if sum := r.Header.Get("X-Upyun-Multi-Md5"); sum != "" && sum != fmt.Sprintf("%x", md5.Sum(data)) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"code": %d, "msg": "md5 mismatch"}`, ErrCodeMD5Mismatch)
+				return
+			}
+			delete(b.uploads, uploadID)
+			b.objects[name] = &fakeObject{data: data, modTime: time.Now().Truncate(time.Second)}
+		default:
+			data, _ := ioutil.ReadAll(r.Body)
+			b.objects[name] = &fakeObject{data: data, modTime: time.Now().Truncate(time.Second)}
+		}
+	case "POST":
+		if r.Header.Get("Folder") == "true" {
+			b.objects[name] = &fakeObject{isDir: true, modTime: time.Now().Truncate(time.Second)}
+			return
+		}
+		b.form(w, r)
+	case "DELETE":
+		delete(b.objects, name)
+	case "HEAD":
+		o := b.objects[name]
+		if o == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if o.isDir {
+			w.Header().Set("x-upyun-file-type", "folder")
+		} else {
+			w.Header().Set("x-upyun-file-type", "file")
+			w.Header().Set("Content-MD5", fmt.Sprintf("%x", md5.Sum(o.data)))
+			w.Header().Set("ETag", fmt.Sprintf("\"%x\"", md5.Sum(o.data)))
+		}
+		w.Header().Set("x-upyun-file-size", strconv.Itoa(len(o.data)))
+		w.Header().Set("x-upyun-file-date", strconv.FormatInt(o.modTime.Unix(), 10))
+		etag := w.Header().Get("ETag")
+		if im := r.Header.Get("If-Match"); im != "" && im != etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		ims, _ := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if inm := r.Header.Get("If-None-Match"); (inm != "" && inm == etag) ||
+			(inm == "" && !ims.IsZero() && !o.modTime.After(ims)) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	case "GET":
+		if r.Header.Get("X-UpYun-Folder") == "true" {
+			b.list(w, r, name)
+			return
+		}
+		o := b.objects[name]
+		if o == nil || o.isDir {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code": 40400001, "msg": "file or directory not found"}`))
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf("\"%x\"", md5.Sum(o.data)))
+		http.ServeContent(w, r, name, o.modTime, bytes.NewReader(o.data))
+	}
+}
+
+func (b *fakeBucket) list(w http.ResponseWriter, r *http.Request, dir string) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	var names []string
+	for name := range b.objects {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	offset, _ := strconv.Atoi(r.Header.Get("X-List-Iter"))
+	limit, _ := strconv.Atoi(r.Header.Get("X-List-Limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	if offset > len(names) {
+		offset = len(names)
+	}
+	end := offset + limit
+	iter := strconv.Itoa(end)
+	if end >= len(names) {
+		end, iter = len(names), "g2gCZAAEbmV4dGQAA2VvZg"
+	}
+
+	files := &JsonFiles{Iter: iter, Files: []*JsonFileInfo{}}
+	for _, name := range names[offset:end] {
+		o := b.objects[name]
+		f := &JsonFileInfo{Name: name[len(prefix):], Length: int64(len(o.data)), LastModified: o.modTime.Unix()}
+		if o.isDir {
+			f.ContentType = "folder"
+		}
+		files.Files = append(files.Files, f)
+	}
+	json.NewEncoder(w).Encode(files)
+}
+
+func (b *fakeBucket) form(w http.ResponseWriter, r *http.Request) {
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+	data, _ := ioutil.ReadAll(f)
+
+	policy, _ := base64.StdEncoding.DecodeString(r.FormValue("policy"))
+	var options map[string]interface{}
+	json.Unmarshal(policy, &options)
+	saveKey, _ := options["save-key"].(string)
+	b.objects[saveKey] = &fakeObject{data: data, modTime: time.Now().Truncate(time.Second)}
+	json.NewEncoder(w).Encode(&FormUploadResp{Code: 200, Url: saveKey})
+}
+
+// live reports whether the tests against a real bucket run, they need
+// UPYUN_BUCKET UPYUN_USERNAME UPYUN_PASSWORD UPYUN_SECRET UPYUN_NOTIFY.
+func live() bool {
+	return os.Getenv("UPYUN_BUCKET") != ""
+}
+
+func requireLive(t *testing.T) {
+	if !live() {
+		t.Skip("UPYUN_BUCKET is not set")
+	}
+}
+
 func TestMain(m *testing.M) {
+	flag.Parse()
+	if !live() {
+		os.Exit(m.Run())
+	}
+
 	_, err := up.Usage()
 	if err != nil {
 		fmt.Println("failed to login. Have set UPYUN_BUCKET UPYUN_USERNAME UPYUN_PASSWORD UPYUN_SECRET UPYUN_NOTIFY?\n", err)
@@ -134,7 +373,6 @@ func TestMain(m *testing.M) {
 		}
 	}
 
-	flag.Parse()
 	code := m.Run()
 
 	clean()
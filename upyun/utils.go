@@ -5,7 +5,6 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -39,6 +38,15 @@ var (
 	hexMap = "0123456789ABCDEF"
 )
 
+// copyHeaders returns a copy of h which can be modified, h may be nil.
+func copyHeaders(h map[string]string) map[string]string {
+	c := make(map[string]string, len(h)+4)
+	for k, v := range h {
+		c[k] = v
+	}
+	return c
+}
+
 func makeRFC1123Date(d time.Time) string {
 	utc := d.UTC().Format(time.RFC1123)
 	return strings.ReplaceAll(utc, "UTC", "GMT")
@@ -235,14 +243,6 @@ func parseBodyToFileInfos(b []byte) (iter string, fInfos []*FileInfo, err error)
 	return
 }
 
-func fileBufMd5(f *fragmentFile) (string, error) {
-	buf := make([]byte, f.offset)
-	f.Read(buf)
-	sum := md5.Sum(buf)
-	defer func() { _, _ = f.Seek(0, 0) }()
-	return hex.EncodeToString(sum[:]), nil
-}
-
 func isFileExpired(fileinfo os.FileInfo, fsize int64) bool {
 	return fileinfo.ModTime().Add(24*time.Hour).Before(time.Now()) && fsize == fileinfo.Size()
 }